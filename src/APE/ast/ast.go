@@ -2,8 +2,11 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"APE/token"
+	"math/big"
 	"strings"
+	"unicode/utf8"
 )
 
 
@@ -17,6 +20,8 @@ func (ae *AssignmentExpression) expressionNode() {}
 
 func (ae *AssignmentExpression) TokenLiteral() string { return ae.Token.Literal }
 
+func (ae *AssignmentExpression) Pos() token.Position { return ae.Token.Pos }
+
 func (ae *AssignmentExpression) String() string {
 	var out bytes.Buffer 
 	
@@ -28,6 +33,31 @@ func (ae *AssignmentExpression) String() string {
 	return out.String()
 }
 
+// IndexAssignmentExpression stores into an array element or hash entry, arr[i] = v
+type IndexAssignmentExpression struct {
+	Token token.Token
+	Target *IndexExpression
+	Value Expression
+}
+
+func (ia *IndexAssignmentExpression) expressionNode() {}
+
+func (ia *IndexAssignmentExpression) TokenLiteral() string { return ia.Token.Literal }
+
+func (ia *IndexAssignmentExpression) Pos() token.Position { return ia.Token.Pos }
+
+func (ia *IndexAssignmentExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ia.Target.Left.String())
+	out.WriteString("[")
+	out.WriteString(ia.Target.Index.String())
+	out.WriteString("] = ")
+	out.WriteString(ia.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
 
 type BreakStatement struct {
 	Token token.Token
@@ -37,6 +67,8 @@ func (bs *BreakStatement) statementNode() {}
 
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
 
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos }
+
 func (bs *BreakStatement) String() string { return bs.TokenLiteral() + ";"}
 
 type ContinueStatement struct {
@@ -47,9 +79,74 @@ func (cs *ContinueStatement) statementNode() {}
 
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
 
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos }
+
 func (cs *ContinueStatement) String() string { return cs.Token.Literal }
 
 
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+
+func (ts *ThrowStatement) Pos() token.Position { return ts.Token.Pos }
+
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+
+// try { Block } catch (Param) { Catch } finally { Finally }, either the catch or the finally clause may be left out
+type TryExpression struct {
+	Token token.Token
+	Block *BlockStatement
+	Param *Identifier
+	Catch *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+
+func (te *TryExpression) Pos() token.Position { return te.Token.Pos }
+
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch (")
+		out.WriteString(te.Param.String())
+		out.WriteString(") ")
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+
 type ForExpression struct {
 	Token token.Token
 	Init Statement
@@ -62,6 +159,8 @@ func (fe *ForExpression) expressionNode() {}
 
 func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
 
+func (fe *ForExpression) Pos() token.Position { return fe.Token.Pos }
+
 func (fe *ForExpression) String() string {
 	var out bytes.Buffer
 
@@ -100,7 +199,92 @@ func (sl *StringLiteral) expressionNode() { }
 
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 
-func (sl *StringLiteral) String() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position { return sl.Token.Pos }
+
+func (sl *StringLiteral) String() string { return quote(sl.Value, '"') }
+
+// InterpolatedString is a string with ${} expressions in it, Strings holds the text around them so it always
+// has one more element than Expressions
+type InterpolatedString struct {
+	Token token.Token // the TEMPLATE_HEAD token
+	Strings []string
+	Expressions []Expression
+}
+
+func (is *InterpolatedString) expressionNode() {}
+
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+
+func (is *InterpolatedString) Pos() token.Position { return is.Token.Pos }
+
+func (is *InterpolatedString) String() string {
+	var out strings.Builder
+
+	out.WriteString(`"`)
+	for i, text := range is.Strings {
+		quoted := quote(text, '"')
+		out.WriteString(quoted[1 : len(quoted)-1])
+
+		if i < len(is.Expressions) {
+			out.WriteString("${")
+			out.WriteString(is.Expressions[i].String())
+			out.WriteString("}")
+		}
+	}
+	out.WriteString(`"`)
+
+	return out.String()
+}
+
+// quote writes s back out as a literal between q quotes, escaping whatever the lexer would not read back as itself
+func quote(s string, q rune) string {
+	var out strings.Builder
+	out.WriteRune(q)
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		switch {
+		case r == q:
+			out.WriteRune('\\')
+			out.WriteRune(q)
+		case r == '\\':
+			out.WriteString(`\\`)
+		case r == '$' && q == '"' && strings.HasPrefix(s[i+size:], "{"): // would start an interpolation
+			out.WriteString(`\$`)
+		case r == '\n':
+			out.WriteString(`\n`)
+		case r == '\t':
+			out.WriteString(`\t`)
+		case r == '\r':
+			out.WriteString(`\r`)
+		case r == utf8.RuneError && size == 1:
+			out.WriteByte(s[i]) // not UTF-8, keep the byte as it was
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&out, "\\u{%x}", r)
+		default:
+			out.WriteString(s[i : i+size])
+		}
+
+		i += size
+	}
+
+	out.WriteRune(q)
+	return out.String()
+}
+
+type CharLiteral struct {
+	Token token.Token
+	Value rune
+}
+
+func (cl *CharLiteral) expressionNode() {}
+
+func (cl *CharLiteral) TokenLiteral() string { return cl.Token.Literal }
+
+func (cl *CharLiteral) Pos() token.Position { return cl.Token.Pos }
+
+func (cl *CharLiteral) String() string { return quote(string(cl.Value), '\'') }
 
 
 type WhileExpression struct {
@@ -113,6 +297,8 @@ func (we *WhileExpression) expressionNode() {}
 
 func (we *WhileExpression) TokenLiteral() string { return we.Token.Literal }
 
+func (we *WhileExpression) Pos() token.Position { return we.Token.Pos }
+
 func (we *WhileExpression) String() string {
 	var out bytes.Buffer
 
@@ -136,6 +322,8 @@ func (ls *LetStatement) statementNode() {}
 
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
 
+func (ls *LetStatement) Pos() token.Position { return ls.Token.Pos }
+
 func (ls *LetStatement) String() string { // This is writing and retrieving the total let statement expression here 
 	var out bytes.Buffer
 
@@ -152,6 +340,62 @@ func (ls *LetStatement) String() string { // This is writing and retrieving the
 	return out.String()
 }
 
+// TypedLetStatement is a let with a type annotation, let x: int = 7;
+type TypedLetStatement struct {
+	Token token.Token
+	Name *Identifier
+	Type *TypeAnnotation
+	Value Expression
+}
+
+func (ls *TypedLetStatement) statementNode() {}
+
+func (ls *TypedLetStatement) TokenLiteral() string { return ls.Token.Literal }
+
+func (ls *TypedLetStatement) Pos() token.Position { return ls.Token.Pos }
+
+func (ls *TypedLetStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ls.TokenLiteral() + " ")
+	out.WriteString(ls.Name.String())
+	out.WriteString(": ")
+	out.WriteString(ls.Type.String())
+	out.WriteString(" = ")
+
+	if ls.Value != nil {
+		out.WriteString(ls.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// TypeAnnotation is a type name with its type parameters, like int or hash<string, array<int>>
+type TypeAnnotation struct {
+	Token token.Token
+	Name string
+	Params []*TypeAnnotation
+}
+
+func (ta *TypeAnnotation) TokenLiteral() string { return ta.Token.Literal }
+
+func (ta *TypeAnnotation) Pos() token.Position { return ta.Token.Pos }
+
+func (ta *TypeAnnotation) String() string {
+	if len(ta.Params) == 0 {
+		return ta.Name
+	}
+
+	params := []string{}
+	for _, p := range ta.Params {
+		params = append(params, p.String())
+	}
+
+	return ta.Name + "<" + strings.Join(params, ", ") + ">"
+}
+
 type ReturnStatement struct {
 	Token token.Token
 	ReturnValue Expression
@@ -161,6 +405,8 @@ func (rs *ReturnStatement) statementNode() {}
 
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+
 func (rs *ReturnStatement) String() string { // makes the return statement have a human readable form 
 	var out bytes.Buffer
 
@@ -186,6 +432,8 @@ func (hl *HashLiteral) expressionNode() {}
 
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 
+func (hl *HashLiteral) Pos() token.Position { return hl.Token.Pos }
+
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -212,6 +460,8 @@ func (ie *IndexExpression) expressionNode() {}
 
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 
+func (ie *IndexExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	
@@ -224,6 +474,38 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression is left[low:high], either bound can be left out
+type SliceExpression struct {
+	Token token.Token // the [ token
+	Left Expression
+	Low Expression
+	High Expression
+}
+
+func (se *SliceExpression) expressionNode() {}
+
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+
+func (se *SliceExpression) Pos() token.Position { return se.Token.Pos }
+
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 type ArrayLiteral struct {
 	Token token.Token
 	Elements []Expression 
@@ -233,6 +515,8 @@ func (al *ArrayLiteral) expressionNode() {}
 
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
 
+func (al *ArrayLiteral) Pos() token.Position { return al.Token.Pos }
+
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -263,6 +547,8 @@ func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -284,7 +570,10 @@ type FunctionLiteral struct {
 	// token ->  fn(parameters -> a, b, c) body -> {} ; fn(abc) {}
 	Token token.Token
 	Parameters []*Identifier
+	Defaults []Expression // the default value of each parameter, nil for parameters that have to be passed
+	Rest *Identifier // collects the arguments after the parameters into an array, nil when there is none
 	Body *BlockStatement 
+	Locals int // slots a call needs for its parameters and lets, set by the resolver
 }
 
 
@@ -292,15 +581,23 @@ func (fl *FunctionLiteral) expressionNode() {}
 
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
 
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if def := fl.Default(i); def != nil {
+			params = append(params, p.String()+" = "+def.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
 	}
-
 
 	out.WriteString("fn")
 	out.WriteString("(")
@@ -311,6 +608,24 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// Default returns the default value of parameter i, nil when it has none
+func (fl *FunctionLiteral) Default(i int) Expression {
+	if i >= len(fl.Defaults) {
+		return nil
+	}
+	return fl.Defaults[i]
+}
+
+// Required is how many parameters a call has to pass, the ones before the first default
+func (fl *FunctionLiteral) Required() int {
+	for i := range fl.Parameters {
+		if fl.Default(i) != nil {
+			return i
+		}
+	}
+	return len(fl.Parameters)
+}
+
 type CallExpression struct {
 	Token token.Token
 	Function Expression
@@ -321,6 +636,8 @@ func (ce *CallExpression) expressionNode() {}
 
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 
+func (ce *CallExpression) Pos() token.Position { return ce.Token.Pos }
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer 
 
@@ -350,6 +667,8 @@ func (mc *MethodCallExpression) expressionNode() {}
 
 func (mc *MethodCallExpression) TokenLiteral() string { return mc.Token.Literal }
 
+func (mc *MethodCallExpression) Pos() token.Position { return mc.Token.Pos }
+
 func (mc *MethodCallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -378,6 +697,8 @@ func (bs *BlockStatement) statementNode() {}
 
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal}
 
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
 func (bs *BlockStatement) String() string { 
 	var out bytes.Buffer
 
@@ -398,6 +719,8 @@ func (es *ExpressionStatement) statementNode() {}
 
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 
+func (es *ExpressionStatement) Pos() token.Position { return es.Token.Pos }
+
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -415,6 +738,8 @@ func (b *Boolean) expressionNode() { }
 
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal}
 
+func (b *Boolean) Pos() token.Position { return b.Token.Pos }
+
 func (b *Boolean) String() string { return b.Token.Literal }
 
 
@@ -422,14 +747,30 @@ func (b *Boolean) String() string { return b.Token.Literal }
 type IntegerLiteral struct { 
 	Token token.Token
 	Value int64
+	Big *big.Int // the value of a literal too large for an int64, nil otherwise
 }
 
 func (il *IntegerLiteral) expressionNode() {}
 
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal}
 
+func (il *IntegerLiteral) Pos() token.Position { return il.Token.Pos }
+
 func (il *IntegerLiteral) String() string {return il.Token.Literal} // used for more human readable code in the test cases
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+
+func (fl *FloatLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FloatLiteral) String() string { return fl.Token.Literal }
+
 type InfixExpression struct {
 	Token token.Token
 	Left Expression
@@ -443,6 +784,8 @@ func (ie *InfixExpression) TokenLiteral() string {
     return ie.Token.Literal 
 }
 
+func (ie *InfixExpression) Pos() token.Position { return ie.Token.Pos }
+
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -466,6 +809,8 @@ func (pe *PrefixExpression) expressionNode() {}
 
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal}
 
+func (pe *PrefixExpression) Pos() token.Position { return pe.Token.Pos }
+
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -480,6 +825,11 @@ func (pe *PrefixExpression) String() string {
 type Identifier struct {
 	Token token.Token
 	Value string
+
+	// filled in by the resolver, the variable lives in Slot of the scope Depth levels out. Builtins resolve to Depth -1
+	Resolved bool
+	Depth int
+	Slot int
 }
 
 func (i *Identifier) String() string {
@@ -494,9 +844,12 @@ func (i *Identifier) String() string {
 func (i *Identifier) expressionNode() {}
 
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
  
 type Node interface {
 	TokenLiteral() string
+	Pos() token.Position // where the node's token is, used for error reporting. For infix, call, index and method call nodes that is the operator, (, [ or . after the left operand rather than where the node starts
 	String() string // used for debugging and logging purposes
 }
 
@@ -524,6 +877,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
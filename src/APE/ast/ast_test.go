@@ -26,3 +26,24 @@ func testString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestStringLiteralString(t *testing.T) {
+	tests := []struct {
+		value string
+		expected string
+	}{
+		{"plain", `"plain"`},
+		{"line\nbreak\ttab", `"line\nbreak\ttab"`},
+		{`say "hi" \ bye`, `"say \"hi\" \\ bye"`},
+		{"bell\x07", `"bell\u{7}"`},
+		{"é😀", `"é😀"`},
+	}
+
+	for _, tt := range tests {
+		sl := &StringLiteral{Token: token.Token{Type: token.STRING, Literal: tt.value}, Value: tt.value}
+
+		if sl.String() != tt.expected {
+			t.Errorf("String() wrong. want=%s, got=%s", tt.expected, sl.String())
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"APE/token"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string { // disassembles the instructions, one per line prefixed with its offset
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		if def.Name == "OpBinary" || def.Name == "OpPrefix" {
+			return fmt.Sprintf("%s %s", def.Name, Operators[operands[0]])
+		}
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpNull
+	OpTrue
+	OpFalse
+
+	OpBinary // operand is an index into Operators
+	OpPrefix // operand is an index into Operators
+
+	OpJump
+	OpJumpNotTruthy // pops the condition
+
+	OpGetGlobal
+	OpSetGlobal // pops the value
+	OpAssignGlobal // like OpSetGlobal but the global has to have been declared already
+	OpGetLocal
+	OpSetLocal // pops the value
+	OpJumpIfSet // operands are a local and an address, jumps when the local holds a value, skipping a parameter's default
+	OpBox // gives a local a fresh cell so closures created from now on share it, a parameter's value moves into the cell
+	OpGetFree // operands are how many functions out the variable lives and its slot there
+	OpSetFree // pops the value
+	OpGetBuiltin
+
+	OpArray
+	OpHash
+	OpInterpolate // operand is how many values to pop, they are joined into a string the way they print
+	OpIndex
+	OpSlice // pops the container and both bounds, a bound that was left out is null
+	OpSetIndex // pops the container, index and value, stores the value and pushes it back
+
+	OpCall
+	OpMethodCall // operands are the constant holding the method name and the argument count
+	OpReturnValue
+	OpReturn
+	OpClosure
+
+	OpThrow
+	OpTry // operand is the address of the handler, which starts with the error on the stack
+	OpEndTry
+	OpCatch // turns the error on top of the stack into the hash a catch block binds
+
+	OpCheckType // operands are the constants holding the declared type and the variable's name, leaves the value in place
+	OpCheckElement // like OpCheckType for the index and value on top of the stack, the type is the container's
+)
+
+type Definition struct {
+	Name string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop: {"OpPop", []int{}},
+	OpNull: {"OpNull", []int{}},
+	OpTrue: {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpBinary: {"OpBinary", []int{1}},
+	OpPrefix: {"OpPrefix", []int{1}},
+	OpJump: {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpGetGlobal: {"OpGetGlobal", []int{2}},
+	OpSetGlobal: {"OpSetGlobal", []int{2}},
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+	OpGetLocal: {"OpGetLocal", []int{2}},
+	OpSetLocal: {"OpSetLocal", []int{2}},
+	OpJumpIfSet: {"OpJumpIfSet", []int{2, 2}},
+	OpBox: {"OpBox", []int{2}},
+	OpGetFree: {"OpGetFree", []int{1, 2}},
+	OpSetFree: {"OpSetFree", []int{1, 2}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
+	OpArray: {"OpArray", []int{2}},
+	OpHash: {"OpHash", []int{2}},
+	OpInterpolate: {"OpInterpolate", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+	OpSlice: {"OpSlice", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{}},
+	OpCall: {"OpCall", []int{1}},
+	OpMethodCall: {"OpMethodCall", []int{2, 1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn: {"OpReturn", []int{}},
+	OpClosure: {"OpClosure", []int{2}},
+	OpThrow: {"OpThrow", []int{}},
+	OpTry: {"OpTry", []int{2}},
+	OpEndTry: {"OpEndTry", []int{}},
+	OpCatch: {"OpCatch", []int{}},
+	OpCheckType: {"OpCheckType", []int{2, 2}},
+	OpCheckElement: {"OpCheckElement", []int{2, 2}},
+}
+
+// Operators are the infix and prefix operators OpBinary and OpPrefix refer to by index
+var Operators = []string{"+", "-", "*", "/", "%", "<", ">", "<=", ">=", "==", "!=", "&", "|", "^", "<<", ">>", "!", "~"}
+
+func LookupOperator(operator string) (int, bool) {
+	for i, op := range Operators {
+		if op == operator {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+func Make(op Opcode, operands ...int) []byte { // encodes an opcode and its operands big endian
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 { return binary.BigEndian.Uint16(ins) }
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
+
+// SourceMap records the source position each instruction was compiled from, in increasing offset order
+type SourceMap struct {
+	offsets []int
+	positions []token.Position
+}
+
+func (sm *SourceMap) Add(offset int, pos token.Position) {
+	if n := len(sm.offsets); n > 0 && sm.positions[n-1] == pos {
+		return // consecutive instructions from the same node share an entry
+	}
+
+	sm.offsets = append(sm.offsets, offset)
+	sm.positions = append(sm.positions, pos)
+}
+
+// Lookup returns the position of the instruction covering offset
+func (sm *SourceMap) Lookup(offset int) token.Position {
+	i := sort.SearchInts(sm.offsets, offset+1) - 1
+	if i < 0 {
+		return token.Position{}
+	}
+	return sm.positions[i]
+}
@@ -0,0 +1,119 @@
+package code
+
+import (
+	"APE/token"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpGetLocal, []int{258}, []byte{byte(OpGetLocal), 1, 2}},
+		{OpBinary, []int{3}, []byte{byte(OpBinary), 3}},
+		{OpGetFree, []int{2, 7}, []byte{byte(OpGetFree), 2, 0, 7}},
+		{OpMethodCall, []int{1, 2}, []byte{byte(OpMethodCall), 0, 1, 2}},
+		{OpPop, []int{}, []byte{byte(OpPop)}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Errorf("instruction has wrong length. want=%d, got=%d", len(tt.expected), len(instruction))
+			continue
+		}
+
+		for i, b := range tt.expected {
+			if instruction[i] != b {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d", i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpConstant, 1),
+		Make(OpBinary, 0),
+		Make(OpGetFree, 1, 3),
+		Make(OpJumpNotTruthy, 65535),
+		Make(OpPop),
+	}
+
+	expected := `0000 OpConstant 1
+0003 OpBinary +
+0005 OpGetFree 1 3
+0009 OpJumpNotTruthy 65535
+0012 OpPop
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op Opcode
+		operands []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpCall, []int{255}, 1},
+		{OpSetFree, []int{3, 513}, 3},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	sm := &SourceMap{}
+	sm.Add(0, token.Position{Line: 1, Column: 1})
+	sm.Add(3, token.Position{Line: 1, Column: 1})
+	sm.Add(5, token.Position{Line: 2, Column: 4})
+	sm.Add(9, token.Position{Line: 3, Column: 2})
+
+	tests := []struct {
+		offset int
+		expected string
+	}{
+		{0, "1:1"},
+		{4, "1:1"},
+		{5, "2:4"},
+		{8, "2:4"},
+		{20, "3:2"},
+	}
+
+	for _, tt := range tests {
+		if got := sm.Lookup(tt.offset).String(); got != tt.expected {
+			t.Errorf("wrong position for offset %d. want=%s, got=%s", tt.offset, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package compiler
+
+import "APE/ast"
+
+// capturedNames collects every name used inside a function nested in node, node itself does not count when it is
+// a function. It goes by name alone, so a variable that is only shadowed in the closure is boxed all the same.
+func capturedNames(node ast.Node) map[string]bool {
+	w := &captureWalker{names: map[string]bool{}}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		w.statements(node.Statements)
+	case *ast.FunctionLiteral:
+		w.function(node)
+	}
+
+	return w.names
+}
+
+type captureWalker struct {
+	names map[string]bool
+	depth int // how many functions in from the one being compiled
+}
+
+func (w *captureWalker) use(name *ast.Identifier) {
+	if name != nil && w.depth > 0 {
+		w.names[name.Value] = true
+	}
+}
+
+func (w *captureWalker) function(fn *ast.FunctionLiteral) {
+	for i := range fn.Parameters {
+		w.expression(fn.Default(i))
+	}
+	w.block(fn.Body)
+}
+
+func (w *captureWalker) statements(statements []ast.Statement) {
+	for _, s := range statements {
+		w.statement(s)
+	}
+}
+
+func (w *captureWalker) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		w.use(s.Name)
+		w.expression(s.Value)
+	case *ast.TypedLetStatement:
+		w.use(s.Name)
+		w.expression(s.Value)
+	case *ast.ReturnStatement:
+		w.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		w.expression(s.Value)
+	case *ast.ExpressionStatement:
+		w.expression(s.Expression)
+	case *ast.BlockStatement:
+		w.block(s)
+	}
+}
+
+func (w *captureWalker) block(b *ast.BlockStatement) {
+	if b != nil {
+		w.statements(b.Statements)
+	}
+}
+
+func (w *captureWalker) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		w.use(exp)
+	case *ast.AssignmentExpression:
+		w.use(exp.Name)
+		w.expression(exp.Value)
+	case *ast.IndexAssignmentExpression:
+		w.expression(exp.Target.Left)
+		w.expression(exp.Target.Index)
+		w.expression(exp.Value)
+	case *ast.FunctionLiteral:
+		w.depth++
+		w.function(exp)
+		w.depth--
+	case *ast.ArrayLiteral:
+		for _, el := range exp.Elements {
+			w.expression(el)
+		}
+	case *ast.HashLiteral:
+		for k, v := range exp.Pairs {
+			w.expression(k)
+			w.expression(v)
+		}
+	case *ast.InterpolatedString:
+		for _, e := range exp.Expressions {
+			w.expression(e)
+		}
+	case *ast.PrefixExpression:
+		w.expression(exp.Right)
+	case *ast.InfixExpression:
+		w.expression(exp.Left)
+		w.expression(exp.Right)
+	case *ast.IfExpression:
+		w.expression(exp.Condition)
+		w.block(exp.Consequence)
+		w.block(exp.Alternative)
+	case *ast.WhileExpression:
+		w.expression(exp.Condition)
+		w.block(exp.Body)
+	case *ast.ForExpression:
+		if exp.Init != nil {
+			w.statement(exp.Init)
+		}
+		w.expression(exp.Condition)
+		w.expression(exp.Update)
+		w.block(exp.Body)
+	case *ast.TryExpression:
+		w.block(exp.Block)
+		w.block(exp.Catch)
+		w.block(exp.Finally)
+	case *ast.CallExpression:
+		w.expression(exp.Function)
+		for _, a := range exp.Arguments {
+			w.expression(a)
+		}
+	case *ast.MethodCallExpression:
+		w.expression(exp.Object)
+		for _, a := range exp.Arguments {
+			w.expression(a)
+		}
+	case *ast.IndexExpression:
+		w.expression(exp.Left)
+		w.expression(exp.Index)
+	case *ast.SliceExpression:
+		w.expression(exp.Left)
+		w.expression(exp.Low)
+		w.expression(exp.High)
+	}
+}
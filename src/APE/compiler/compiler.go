@@ -0,0 +1,929 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"APE/ast"
+	"APE/code"
+	"APE/evaluator"
+	"APE/object"
+	"APE/token"
+	"APE/typecheck"
+)
+
+// Bytecode is a compiled program, GlobalNames holds the name of every global slot so the VM can report undefined ones
+// and NumLocals is how many slots the main frame needs for the variables of blocks in the global scope
+type Bytecode struct {
+	Instructions code.Instructions
+	SourceMap *code.SourceMap
+	Constants []object.Object
+	GlobalNames []string
+	NumLocals int
+}
+
+type Compiler struct {
+	constants []object.Object
+	symbolTable *SymbolTable
+
+	scopes []CompilationScope
+	scopeIndex int
+
+	pos token.Position // position of the node being compiled, recorded for every instruction emitted
+}
+
+// CompilationScope is the function currently being compiled
+type CompilationScope struct {
+	instructions code.Instructions
+	sourceMap *code.SourceMap
+	names map[int]string
+	controls []*control
+	captured map[string]bool // names functions nested in this one refer to, their locals are boxed
+}
+
+// control is a loop or try block that break, continue and return have to leave properly
+type control struct {
+	isLoop bool
+	breaks []int // positions of jumps to patch once the loop is compiled
+	continues []int
+
+	hasHandler bool // a try handler is installed while compiling this block
+	finally *ast.BlockStatement
+}
+
+func New() *Compiler {
+	return NewWithState(NewSymbolTable(), []object.Object{})
+}
+
+// NewWithState lets a REPL keep its globals and constants between inputs
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	mainScope := CompilationScope{instructions: code.Instructions{}, sourceMap: &code.SourceMap{}, names: map[int]string{}}
+
+	return &Compiler{
+		constants: constants,
+		symbolTable: s,
+		scopes: []CompilationScope{mainScope},
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		SourceMap: c.scopes[c.scopeIndex].sourceMap,
+		Constants: c.constants,
+		GlobalNames: c.symbolTable.GlobalNames(),
+		NumLocals: c.symbolTable.NumMainLocals(),
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	prevPos := c.pos
+	if pos := node.Pos(); pos.IsValid() {
+		c.pos = pos
+	}
+	defer func() { c.pos = prevPos }()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		// the program leaves the value of its last statement for the VM to return, like Eval does
+		c.scopes[c.scopeIndex].captured = capturedNames(node)
+		if err := c.compileStatements(node.Statements, true); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.LetStatement:
+		return c.compileLetStatement(node, false)
+
+	case *ast.TypedLetStatement:
+		return c.compileTypedLetStatement(node, false)
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			c.emit(code.OpNull)
+		} else if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.leaveControls(len(c.scopes[c.scopeIndex].controls), false); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.BreakStatement:
+		return c.compileJumpOut(node)
+
+	case *ast.ContinueStatement:
+		return c.compileJumpOut(node)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.BlockStatement:
+		return c.compileBlock(node, true)
+
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: node.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		}
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.InterpolatedString:
+		parts := 0
+		for i, text := range node.Strings {
+			if text != "" {
+				c.emit(code.OpConstant, c.addConstant(&object.String{Value: text}))
+				parts++
+			}
+			if i < len(node.Expressions) {
+				if err := c.Compile(node.Expressions[i]); err != nil {
+					return err
+				}
+				parts++
+			}
+		}
+		c.emit(code.OpInterpolate, parts)
+
+	case *ast.CharLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Char{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := code.LookupOperator(node.Operator)
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		c.emit(code.OpPrefix, op)
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := code.LookupOperator(node.Operator)
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		c.emit(code.OpBinary, op)
+
+	case *ast.Identifier:
+		c.loadName(node.Value)
+
+	case *ast.AssignmentExpression:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if !ok { // it can still be declared as a global before this runs, the VM checks that it was
+			symbol = c.symbolTable.DefineGlobal(node.Name.Value)
+		}
+		if symbol.Type != nil {
+			c.emitCheckType(symbol.Name, symbol.Type)
+		}
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpAssignGlobal, symbol.Index)
+		} else {
+			c.storeSymbol(symbol)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.IndexAssignmentExpression:
+		if err := c.Compile(node.Target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if typ := c.declaredType(node.Target.Left); typ != nil {
+			typeIndex := c.addConstant(&object.Type{Annotation: typ})
+			nameIndex := c.addConstant(&object.String{Value: node.Target.Left.String()})
+			c.emit(code.OpCheckElement, typeIndex, nameIndex)
+		}
+		c.emit(code.OpSetIndex)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.WhileExpression:
+		return c.compileWhileExpression(node)
+
+	case *ast.ForExpression:
+		return c.compileForExpression(node)
+
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// the pairs are a Go map, sort them so the same program always compiles to the same bytecode
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+			} else if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node, "")
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+
+	case *ast.MethodCallExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpMethodCall, c.addConstant(&object.String{Value: node.Method}), len(node.Arguments))
+
+	default:
+		return fmt.Errorf("%s: cannot compile %T", node.Pos(), node)
+	}
+
+	return nil
+}
+
+// compileStatements compiles a statement list, with keep set the value of the last statement is left on the stack
+func (c *Compiler) compileStatements(statements []ast.Statement, keep bool) error {
+	if len(statements) == 0 {
+		if keep {
+			c.emit(code.OpNull)
+		}
+		return nil
+	}
+
+	for i, s := range statements {
+		if keep && i == len(statements)-1 {
+			if err := c.compileValueStatement(s); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileBlock(block *ast.BlockStatement, keep bool) error {
+	if block == nil {
+		if keep {
+			c.emit(code.OpNull)
+		}
+		return nil
+	}
+
+	prevPos := c.pos
+	c.pos = block.Pos()
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() {
+		c.pos = prevPos
+		c.symbolTable = c.symbolTable.Outer
+	}()
+
+	return c.compileStatements(block.Statements, keep)
+}
+
+// compileValueStatement compiles s so that it leaves its value on the stack
+func (c *Compiler) compileValueStatement(s ast.Statement) error {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		prevPos := c.pos
+		c.pos = s.Pos()
+		defer func() { c.pos = prevPos }()
+		return c.Compile(s.Expression)
+	case *ast.LetStatement:
+		return c.compileLetStatement(s, true)
+	case *ast.TypedLetStatement:
+		return c.compileTypedLetStatement(s, true)
+	case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement, *ast.ThrowStatement:
+		return c.Compile(s) // control leaves the block so there is no value to leave
+	default:
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		c.emit(code.OpNull)
+		return nil
+	}
+}
+
+func (c *Compiler) compileLetStatement(node *ast.LetStatement, keep bool) error {
+	prevPos := c.pos
+	c.pos = node.Pos()
+	defer func() { c.pos = prevPos }()
+
+	var symbol Symbol
+
+	if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+		// bind the name first so the function can refer to itself, Eval only finds it at call time as well
+		symbol = c.defineLet(node.Name.Value, nil)
+		if err := c.compileFunctionLiteral(fn, node.Name.Value); err != nil {
+			return err
+		}
+	} else {
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol = c.defineLet(node.Name.Value, nil)
+	}
+
+	c.storeSymbol(symbol)
+	if keep {
+		c.loadSymbol(symbol)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileTypedLetStatement(node *ast.TypedLetStatement, keep bool) error {
+	prevPos := c.pos
+	c.pos = node.Pos()
+	defer func() { c.pos = prevPos }()
+
+	var symbol Symbol
+
+	if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+		symbol = c.defineLet(node.Name.Value, node.Type)
+		if err := c.compileFunctionLiteral(fn, node.Name.Value); err != nil {
+			return err
+		}
+	} else {
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol = c.defineLet(node.Name.Value, node.Type)
+	}
+
+	c.emitCheckType(symbol.Name, node.Type)
+	c.storeSymbol(symbol)
+	if keep {
+		c.loadSymbol(symbol)
+	}
+
+	return nil
+}
+
+// defineLet binds the name a let declares. A name new to the current table gets a fresh cell when closures in
+// this function may capture it, so closures created in one run of a block keep that run's variable.
+func (c *Compiler) defineLet(name string, typ *ast.TypeAnnotation) Symbol {
+	fresh := !c.symbolTable.defines(name)
+
+	var symbol Symbol
+	if typ == nil {
+		symbol = c.symbolTable.Define(name)
+	} else {
+		symbol = c.symbolTable.DefineTyped(name, typ)
+	}
+	if fresh {
+		c.boxCaptured(symbol)
+	}
+	return symbol
+}
+
+func (c *Compiler) boxCaptured(s Symbol) {
+	if s.Scope == LocalScope && c.scopes[c.scopeIndex].captured[s.Name] {
+		c.emit(code.OpBox, s.Index)
+	}
+}
+
+func (c *Compiler) emitCheckType(name string, typ *ast.TypeAnnotation) {
+	typeIndex := c.addConstant(&object.Type{Annotation: typ})
+	nameIndex := c.addConstant(&object.String{Value: name})
+	c.emit(code.OpCheckType, typeIndex, nameIndex)
+}
+
+// declaredType is the type of a typed variable, or of an element of one reached by indexing it, nil otherwise
+func (c *Compiler) declaredType(exp ast.Expression) *ast.TypeAnnotation {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if symbol, ok := c.symbolTable.Resolve(exp.Value); ok {
+			return symbol.Type
+		}
+		return nil
+	case *ast.IndexExpression:
+		return typecheck.ElementType(c.declaredType(exp.Left))
+	default:
+		return nil
+	}
+}
+
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "&&" {
+		if err := c.compileTruthiness(node.Right); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpFalse)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	c.emit(code.OpTrue)
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	if err := c.compileTruthiness(node.Right); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileTruthiness leaves true or false depending on whether exp is truthy
+func (c *Compiler) compileTruthiness(exp ast.Expression) error {
+	if err := c.Compile(exp); err != nil {
+		return err
+	}
+	bang, _ := code.LookupOperator("!")
+	c.emit(code.OpPrefix, bang)
+	c.emit(code.OpPrefix, bang)
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlock(node.Consequence, true); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlock(node.Alternative, true); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// loops leave null behind, their bodies are compiled without values so break and continue can jump straight out
+func (c *Compiler) compileWhileExpression(node *ast.WhileExpression) error {
+	loop := &control{isLoop: true}
+	c.pushControl(loop)
+
+	conditionPos := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlock(node.Body, false); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, conditionPos)
+
+	endPos := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, endPos)
+	c.popControl(loop, conditionPos, endPos)
+
+	c.emit(code.OpNull)
+	return nil
+}
+
+func (c *Compiler) compileForExpression(node *ast.ForExpression) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable) // the loop variable only exists inside the loop
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	if node.Init != nil {
+		if err := c.Compile(node.Init); err != nil {
+			return err
+		}
+	}
+
+	loop := &control{isLoop: true}
+	c.pushControl(loop)
+
+	conditionPos := len(c.currentInstructions())
+	jumpNotTruthyPos := -1
+	if node.Condition != nil {
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpNotTruthyPos = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	if err := c.compileBlock(node.Body, false); err != nil {
+		return err
+	}
+
+	updatePos := len(c.currentInstructions())
+	if node.Update != nil {
+		if err := c.Compile(node.Update); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	}
+	c.emit(code.OpJump, conditionPos)
+
+	endPos := len(c.currentInstructions())
+	if jumpNotTruthyPos >= 0 {
+		c.changeOperand(jumpNotTruthyPos, endPos)
+	}
+	c.popControl(loop, updatePos, endPos)
+
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileTryExpression inlines the finally block on every way out: after the try block, after the catch block,
+// and before rethrowing an error that was not caught or that the catch block raised
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	tryPos := c.emit(code.OpTry, 9999)
+
+	block := &control{hasHandler: true, finally: node.Finally}
+	c.pushControl(block)
+	if err := c.compileBlock(node.Block, true); err != nil {
+		return err
+	}
+	c.popControl(block, 0, 0)
+
+	c.emit(code.OpEndTry)
+	if err := c.compileBlock(node.Finally, false); err != nil {
+		return err
+	}
+	endJumps := []int{c.emit(code.OpJump, 9999)}
+
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+
+	if node.Catch != nil {
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+		c.emit(code.OpCatch)
+		param := c.symbolTable.Define(node.Param.Value)
+		c.boxCaptured(param)
+		c.storeSymbol(param)
+
+		catchTryPos := -1
+		if node.Finally != nil {
+			catchTryPos = c.emit(code.OpTry, 9999)
+		}
+
+		catch := &control{hasHandler: node.Finally != nil, finally: node.Finally}
+		c.pushControl(catch)
+		if err := c.compileBlock(node.Catch, true); err != nil {
+			return err
+		}
+		c.popControl(catch, 0, 0)
+		c.symbolTable = c.symbolTable.Outer
+
+		if node.Finally == nil {
+			endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		} else {
+			c.emit(code.OpEndTry)
+			if err := c.compileBlock(node.Finally, false); err != nil {
+				return err
+			}
+			endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+			c.changeOperand(catchTryPos, len(c.currentInstructions()))
+		}
+	}
+
+	if node.Finally != nil { // the error is on the stack, run the finally block and raise it again
+		if err := c.compileBlock(node.Finally, false); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileJumpOut(node ast.Statement) error {
+	_, isBreak := node.(*ast.BreakStatement)
+	controls := c.scopes[c.scopeIndex].controls
+
+	loopIndex := -1
+	for i := len(controls) - 1; i >= 0; i-- {
+		if controls[i].isLoop {
+			loopIndex = i
+			break
+		}
+	}
+
+	if loopIndex < 0 {
+		return fmt.Errorf("%s: %s outside of a loop", node.Pos(), node.TokenLiteral())
+	}
+
+	if err := c.leaveControls(len(controls)-loopIndex-1, true); err != nil {
+		return err
+	}
+
+	loop := controls[loopIndex]
+	pos := c.emit(code.OpJump, 9999)
+	if isBreak {
+		loop.breaks = append(loop.breaks, pos)
+	} else {
+		loop.continues = append(loop.continues, pos)
+	}
+
+	return nil
+}
+
+// leaveControls emits what is needed to jump out of the innermost n try blocks: removing their handlers and
+// running their finally blocks. Returning from the function drops its handlers anyway so only break and continue
+// remove them.
+func (c *Compiler) leaveControls(n int, removeHandlers bool) error {
+	scope := &c.scopes[c.scopeIndex]
+	controls := scope.controls
+
+	for i := len(controls) - 1; i >= len(controls)-n; i-- {
+		ctl := controls[i]
+		if ctl.isLoop {
+			continue
+		}
+
+		if removeHandlers && ctl.hasHandler {
+			c.emit(code.OpEndTry)
+		}
+
+		if ctl.finally != nil {
+			// the finally block runs outside the try it belongs to
+			scope.controls = controls[:i]
+			err := c.compileBlock(ctl.finally, false)
+			scope.controls = controls
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) pushControl(ctl *control) {
+	c.scopes[c.scopeIndex].controls = append(c.scopes[c.scopeIndex].controls, ctl)
+}
+
+func (c *Compiler) popControl(ctl *control, continuePos int, breakPos int) {
+	controls := c.scopes[c.scopeIndex].controls
+	c.scopes[c.scopeIndex].controls = controls[:len(controls)-1]
+
+	for _, pos := range ctl.breaks {
+		c.changeOperand(pos, breakPos)
+	}
+	for _, pos := range ctl.continues {
+		c.changeOperand(pos, continuePos)
+	}
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral, name string) error {
+	prevPos := c.pos
+	c.pos = node.Pos()
+	defer func() { c.pos = prevPos }()
+
+	c.enterScope()
+	c.scopes[c.scopeIndex].captured = capturedNames(node)
+
+	var bound []Symbol // parameters and hoisted functions, boxed before the body runs if closures capture them
+	for _, p := range node.Parameters {
+		bound = append(bound, c.symbolTable.Define(p.Value))
+	}
+	if node.Rest != nil {
+		bound = append(bound, c.symbolTable.Define(node.Rest.Value))
+	}
+
+	// functions bound in the body can call each other whatever order they are defined in, like they can with Eval
+	if node.Body != nil {
+		for _, s := range node.Body.Statements {
+			switch let := s.(type) {
+			case *ast.LetStatement:
+				if _, isFn := let.Value.(*ast.FunctionLiteral); isFn {
+					bound = append(bound, c.symbolTable.Define(let.Name.Value))
+				}
+			case *ast.TypedLetStatement:
+				if _, isFn := let.Value.(*ast.FunctionLiteral); isFn {
+					bound = append(bound, c.symbolTable.DefineTyped(let.Name.Value, let.Type))
+				}
+			}
+		}
+	}
+
+	// parameters the call did not pass get their default before the body runs
+	for i, p := range node.Parameters {
+		def := node.Default(i)
+		if def == nil {
+			continue
+		}
+		symbol, _ := c.symbolTable.Resolve(p.Value)
+		jumpPos := c.emit(code.OpJumpIfSet, symbol.Index, 9999)
+		if err := c.Compile(def); err != nil {
+			return err
+		}
+		c.emit(code.OpSetLocal, symbol.Index)
+		copy(c.currentInstructions()[jumpPos:], code.Make(code.OpJumpIfSet, symbol.Index, len(c.currentInstructions())))
+	}
+	for _, symbol := range bound {
+		c.boxCaptured(symbol)
+	}
+
+	// the body is compiled in the function's own table, where the parameters and hoisted functions are
+	if node.Body == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileStatements(node.Body.Statements, true); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	numLocals := c.symbolTable.NumDefinitions()
+	names := c.scopes[c.scopeIndex].names
+	instructions, sourceMap := c.leaveScope()
+
+	fn := &object.CompiledFunction{
+		Instructions: instructions,
+		SourceMap: sourceMap,
+		NumLocals: numLocals,
+		NumParameters: len(node.Parameters),
+		NumDefaults: len(node.Parameters) - node.Required(),
+		Rest: node.Rest != nil,
+		Name: name,
+		Names: names,
+	}
+
+	c.emit(code.OpClosure, c.addConstant(fn))
+	return nil
+}
+
+// loadName pushes the value of name. Names that are not defined yet become globals so functions can refer to
+// globals defined after them, reading one before it is set is an error at runtime.
+func (c *Compiler) loadName(name string) {
+	if symbol, ok := c.symbolTable.Resolve(name); ok {
+		c.loadSymbol(symbol)
+		return
+	}
+
+	if i, ok := evaluator.BuiltinIndex(name); ok {
+		c.emit(code.OpGetBuiltin, i)
+		return
+	}
+
+	c.loadSymbol(c.symbolTable.DefineGlobal(name))
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.scopes[c.scopeIndex].names[c.emit(code.OpGetLocal, s.Index)] = s.Name
+	case FreeScope:
+		c.scopes[c.scopeIndex].names[c.emit(code.OpGetFree, s.Depth, s.Index)] = s.Name
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Depth, s.Index)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int { // returns the position the instruction was written at
+	ins := code.Make(op, operands...)
+	scope := &c.scopes[c.scopeIndex]
+
+	pos := len(scope.instructions)
+	scope.instructions = append(scope.instructions, ins...)
+	scope.sourceMap.Add(pos, c.pos)
+
+	return pos
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	ins := c.currentInstructions()
+	op := code.Opcode(ins[opPos])
+	newInstruction := code.Make(op, operand)
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[opPos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}, sourceMap: &code.SourceMap{}, names: map[int]string{}})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (code.Instructions, *code.SourceMap) {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope.instructions, scope.sourceMap
+}
@@ -0,0 +1,309 @@
+package compiler
+
+import (
+	"APE/ast"
+	"APE/code"
+	"APE/evaluator"
+	"APE/lexer"
+	"APE/object"
+	"APE/parser"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input string
+	expectedConstants []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBinary, operator("+")),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "1; -2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPrefix, operator("-")),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),                 // 0000
+				code.Make(code.OpJumpNotTruthy, 10),    // 0001
+				code.Make(code.OpConstant, 0),          // 0004
+				code.Make(code.OpJump, 11),             // 0007
+				code.Make(code.OpNull),                 // 0010
+				code.Make(code.OpPop),                  // 0011
+				code.Make(code.OpConstant, 1),          // 0012
+				code.Make(code.OpReturnValue),          // 0015
+			},
+		},
+		{
+			input: "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),                 // 0000
+				code.Make(code.OpJumpNotTruthy, 12),    // 0001
+				code.Make(code.OpFalse),                // 0004
+				code.Make(code.OpPrefix, operator("!")), // 0005
+				code.Make(code.OpPrefix, operator("!")), // 0007
+				code.Make(code.OpJump, 13),             // 0009
+				code.Make(code.OpFalse),                // 0012
+				code.Make(code.OpReturnValue),          // 0013
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let one = 1; let two = one; two;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "len",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, builtinIndex("len")),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 1, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpBinary, operator("+")),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpBox, 0),
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "if (true) { let x = 1; fn() { x } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 1, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 19),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBox, 0),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpJump, 20),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"break;", "1:1: break outside of a loop"},
+		{"fn() { continue; }", "1:8: continue outside of a loop"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("expected a compile error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	outer := NewEnclosedSymbolTable(global)
+	outer.Define("b")
+
+	block := NewBlockSymbolTable(outer)
+	block.Define("c")
+
+	inner := NewEnclosedSymbolTable(block)
+	inner.Define("d")
+
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: FreeScope, Index: 0, Depth: 1},
+		"c": {Name: "c", Scope: FreeScope, Index: 1, Depth: 1},
+		"d": {Name: "d", Scope: LocalScope, Index: 0},
+	}
+
+	for name, want := range expected {
+		got, ok := inner.Resolve(name)
+		if !ok {
+			t.Errorf("name %s not resolvable", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", name, want, got)
+		}
+	}
+
+	if outer.NumDefinitions() != 2 {
+		t.Errorf("block definitions should use the function's slots. want=2, got=%d", outer.NumDefinitions())
+	}
+
+	mainBlock := NewBlockSymbolTable(global)
+	if got := mainBlock.Define("e"); got != (Symbol{Name: "e", Scope: LocalScope, Index: 0}) {
+		t.Errorf("block variables in the global scope should be locals of the main frame, got=%+v", got)
+	}
+	if global.NumDefinitions() != 1 || global.NumMainLocals() != 1 {
+		t.Errorf("wrong slot counts. want globals=1 main locals=1, got globals=%d main locals=%d", global.NumDefinitions(), global.NumMainLocals())
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		testInstructions(t, tt.expectedInstructions, bytecode.Instructions)
+		testConstants(t, tt.expectedConstants, bytecode.Constants)
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func operator(op string) int {
+	i, _ := code.LookupOperator(op)
+	return i
+}
+
+func builtinIndex(name string) int {
+	for i, n := range evaluator.BuiltinNames() {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func testInstructions(t *testing.T, expected []code.Instructions, actual code.Instructions) {
+	t.Helper()
+
+	concatted := concatInstructions(expected)
+	if actual.String() != concatted.String() {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", concatted, actual)
+	}
+}
+
+func testConstants(t *testing.T, expected []interface{}, actual []object.Object) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(expected), len(actual))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				t.Errorf("constant %d - wrong value. want=%d, got=%s", i, constant, actual[i].Inspect())
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d - not a function: %T", i, actual[i])
+				continue
+			}
+			testInstructions(t, constant, fn.Instructions)
+		default:
+			t.Errorf("unhandled constant type %T", constant)
+		}
+	}
+}
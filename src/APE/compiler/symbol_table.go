@@ -0,0 +1,142 @@
+package compiler
+
+import "APE/ast"
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope SymbolScope = "LOCAL"
+	FreeScope SymbolScope = "FREE"
+	BuiltinScope SymbolScope = "BUILTIN"
+)
+
+// Symbol is a resolved name, Depth counts how many functions out a free variable is defined
+type Symbol struct {
+	Name string
+	Scope SymbolScope
+	Index int
+	Depth int
+	Type *ast.TypeAnnotation // declared type, nil for a plain let
+}
+
+// SymbolTable maps names to slots. A function gets its own table, a block inside it gets a table that
+// shares the function's slot counter so its variables live in the same frame but go out of scope with the block.
+// Blocks in the global scope keep their variables in the main frame the same way.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store map[string]Symbol
+	isBlock bool
+	numDefinitions *int
+	numMainLocals *int // the slot counter of blocks in the global scope, only set on the outermost table
+
+	globalNames *[]string // shared by every table, the name of each global slot for runtime errors
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), numDefinitions: new(int), numMainLocals: new(int), globalNames: &[]string{}}
+}
+
+// NewEnclosedSymbolTable starts the table for a function body
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.numMainLocals = nil
+	s.globalNames = outer.globalNames
+	return s
+}
+
+// NewBlockSymbolTable starts a scope inside the current function, or inside the global scope
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	numDefinitions := outer.numDefinitions
+	if outer.numMainLocals != nil {
+		numDefinitions = outer.numMainLocals
+	}
+
+	return &SymbolTable{
+		Outer: outer,
+		store: make(map[string]Symbol),
+		isBlock: true,
+		numDefinitions: numDefinitions,
+		globalNames: outer.globalNames,
+	}
+}
+
+func (s *SymbolTable) NumDefinitions() int { return *s.numDefinitions }
+
+// NumMainLocals is how many slots the main frame needs for the variables of blocks in the global scope
+func (s *SymbolTable) NumMainLocals() int {
+	table := s
+	for table.Outer != nil {
+		table = table.Outer
+	}
+	return *table.numMainLocals
+}
+
+// defines reports whether name is bound in this table itself rather than in one around it
+func (s *SymbolTable) defines(name string) bool {
+	_, ok := s.store[name]
+	return ok
+}
+
+func (s *SymbolTable) GlobalNames() []string { return *s.globalNames }
+
+// Define binds name in this table, defining a name twice in the same table reuses its slot
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		symbol.Type = nil
+		s.store[name] = symbol
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: *s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+		*s.globalNames = append(*s.globalNames, name)
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	*s.numDefinitions += 1
+	return symbol
+}
+
+// DefineTyped binds name like Define and records the type assignments to it have to keep
+func (s *SymbolTable) DefineTyped(name string, typ *ast.TypeAnnotation) Symbol {
+	symbol := s.Define(name)
+	symbol.Type = typ
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineGlobal binds name in the outermost table
+func (s *SymbolTable) DefineGlobal(name string) Symbol {
+	table := s
+	for table.Outer != nil {
+		table = table.Outer
+	}
+	return table.Define(name)
+}
+
+// Resolve looks name up through the enclosing blocks and functions, it does not know about builtins
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	depth := 0
+
+	for table := s; table != nil; table = table.Outer {
+		if symbol, ok := table.store[name]; ok {
+			if symbol.Scope == LocalScope && depth > 0 {
+				symbol.Scope = FreeScope
+				symbol.Depth = depth
+			}
+			return symbol, true
+		}
+
+		if !table.isBlock {
+			depth++
+		}
+	}
+
+	return Symbol{}, false
+}
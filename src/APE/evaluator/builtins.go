@@ -3,7 +3,12 @@ package evaluator
 import (
 	"fmt"
 	"APE/object"
+	"math"
+	"math/big"
 	"math/rand"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 
@@ -16,7 +21,7 @@ var builtins = map[string]*object.Builtin{
 
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			default: 
 			  return newError("argument to `len` not supported, got=%s", args[0].Type())
 				
@@ -104,6 +109,104 @@ var builtins = map[string]*object.Builtin{
 			return NULL
 		},
 	},
+	"int": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				return arg
+			case *object.Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError("cannot convert %s to an integer", arg.Inspect())
+				}
+				if arg.Value >= math.MaxInt64 || arg.Value < math.MinInt64 {
+					value, _ := big.NewFloat(arg.Value).Int(nil)
+					return normalizeInt(value)
+				}
+				return &object.Integer{Value: int64(arg.Value)}
+			case *object.String:
+				value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+				if !ok {
+					return newError("cannot convert %q to an integer", arg.Value)
+				}
+				return normalizeInt(value)
+			default:
+				return newError("argument to `int` not supported, got=%s", args[0].Type())
+			}
+		},
+	},
+	"float": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				return &object.Float{Value: toFloat(arg)}
+			case *object.Float:
+				return arg
+			case *object.String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("cannot convert %q to a float", arg.Value)
+				}
+				return &object.Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got=%s", args[0].Type())
+			}
+		},
+	},
+	"ord": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			arg, ok := args[0].(*object.Char)
+			if !ok {
+				return newError("argument to `ord` must be CHAR, got %s", args[0].Type())
+			}
+			return &object.Integer{Value: int64(arg.Value)}
+		},
+	},
+	"chr": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			arg, ok := args[0].(*object.Integer)
+			if !ok {
+				return newError("argument to `chr` must be INTEGER, got %s", args[0].Type())
+			}
+			if arg.Value < 0 || arg.Value > utf8.MaxRune || !utf8.ValidRune(rune(arg.Value)) {
+				return newError("%d is not a valid character code", arg.Value)
+			}
+			return &object.Char{Value: rune(arg.Value)}
+		},
+	},
+	"bytes": &object.Builtin{ // the UTF-8 bytes of a string, len and indexing count characters instead
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			arg, ok := args[0].(*object.String)
+			if !ok {
+				return newError("argument to `bytes` must be STRING, got %s", args[0].Type())
+			}
+
+			elements := make([]object.Object, len(arg.Value))
+			for i := 0; i < len(arg.Value); i++ {
+				elements[i] = &object.Integer{Value: int64(arg.Value[i])}
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
 	"random": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
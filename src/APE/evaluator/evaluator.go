@@ -4,6 +4,12 @@ import (
 	"fmt"
 	"APE/ast"
 	"APE/object"
+	"APE/token"
+	"APE/typecheck"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
 )
 
 var (
@@ -14,8 +20,34 @@ var (
 	CONTINUE = &object.Continue{}
 )
 
-// switches between availale statments in order to intepret 
+// MaxCallDepth is how deep user function calls can nest before the call fails with a stack overflow error
+const MaxCallDepth = 16384
+
+// Eval interprets node and tags any error it produces with the position of the innermost node that raised it
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+
+	return result
+}
+
+// EvalSafely is Eval for programs run on behalf of a host, like the REPL. A Go panic inside the interpreter
+// becomes an APE error instead of crashing the host.
+func EvalSafely(node ast.Node, env *object.Environment) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+
+	return Eval(node, env)
+}
+
+// switches between availale statments in order to intepret 
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	// statements
@@ -23,8 +55,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalProgram(node.Statements, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
-	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+	case *ast.BlockStatement: // every block is its own scope, a let inside it ends with the block
+		return evalBlockStatement(node, object.NewEnclosedEnvironment(env))
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
@@ -33,13 +65,41 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.ReturnValue{Value: val}
 	case *ast.StringLiteral: 
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
+	case *ast.CharLiteral:
+		return &object.Char{Value: node.Value}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if fn, ok := val.(*object.Function); ok && fn.Name == "" { // name the function after its first binding for stack traces
+			fn.Name = node.Name.Value
+		}
+		if node.Name.Resolved {
+			env.SetAt(node.Name.Slot, val)
+		} else {
+			env.Set(node.Name.Value, val)
+		}
 		return val 
+	case *ast.TypedLetStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if !typecheck.Matches(val, node.Type) {
+			return newError("type mismatch: cannot assign %s to %s (%s)", val.Type(), node.Name.Value, node.Type)
+		}
+		if fn, ok := val.(*object.Function); ok && fn.Name == "" {
+			fn.Name = node.Name.Value
+		}
+		if node.Name.Resolved {
+			env.SetTypedAt(node.Name.Slot, val, node.Type)
+		} else {
+			env.SetTyped(node.Name.Value, val, node.Type)
+		}
+		return val
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	case *ast.Identifier:
@@ -47,20 +107,30 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral: 
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Defaults: node.Defaults, Rest: node.Rest, Env: env, Body: body, Locals: node.Locals}
 	// Expressions
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		return evalPrefixExpression(node.Operator, right)
+	case *ast.IndexAssignmentExpression:
+		return evalIndexAssignmentExpression(node, env)
 	case *ast.AssignmentExpression: 
 		return evalAssignmentExpression(node, env)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -80,6 +150,23 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		low, high := object.Object(NULL), object.Object(NULL)
+		if node.Low != nil {
+			if low = Eval(node.Low, env); isError(low) {
+				return low
+			}
+		}
+		if node.High != nil {
+			if high = Eval(node.High, env); isError(high) {
+				return high
+			}
+		}
+		return evalSliceExpression(left, low, high)
 	case *ast.CallExpression: 
 		function := Eval(node.Function, env)
 		if isError(function) { 
@@ -89,7 +176,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(function, args, node.Pos(), env)
 	case *ast.MethodCallExpression:
 		o := Eval(node.Object, env)
 		if isError(o) {
@@ -100,82 +187,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return a[0]
 		}
 
-		// Here we ONLY check type using a type switch
-		switch arr := o.(type) {
-		case *object.Array:
-			// It's an array
-			if node.Method == "map" {
-				// Handle map
-				if len(a) != 1 {
-					return newError("wrong number of arguments for map")
-				}
-				
-				fn, ok := a[0].(*object.Function)
-				if !ok {
-					return newError("argument to map must be a function")
-				}
-				
-				result := []object.Object{}
-				for _, e := range arr.Elements {
-					val := applyFunction(fn, []object.Object{e})
-					if isError(val) {
-						return val
-					}
-					result = append(result, val)
-				}
-				
-				return &object.Array{Elements: result}
-			} else if node.Method == "filter" {
-				// Handle filter
-				if len(a) != 1 {
-					return newError("wrong number of arguments for filter")
-				}
-				
-				fn, ok := a[0].(*object.Function)
-				if !ok {
-					return newError("argument to filter must be a function")
-				}
-				
-				result := []object.Object{}
-				for _, e := range arr.Elements {
-					condition := applyFunction(fn, []object.Object{e})
-					if isError(condition) {
-						return condition
-					}
-					
-					if isTruthy(condition) {
-						result = append(result, e)
-					}
-				}
-				
-				return &object.Array{Elements: result}
-			} else if node.Method == "reduce" {
-				// Handle reduce
-				if len(a) != 2 {
-					return newError("wrong number of arguments for reduce")
-				}
-				
-				fn, ok := a[0].(*object.Function)
-				if !ok {
-					return newError("first argument to reduce must be a function")
-				}
-				
-				accum := a[1]
-				for _, e := range arr.Elements {
-					accum = applyFunction(fn, []object.Object{accum, e})
-					if isError(accum) {
-						return accum
-					}
-				}
-				
-				return accum
-			} else {
-				return newError("unknown method %s", node.Method)
-			}
-		default:
-			// Not an array
-			return newError("no methods for this type")
-		}
+		return callMethod(o, node.Method, a, func(fn object.Object, args []object.Object) object.Object {
+			return applyFunction(fn, args, node.Pos(), env)
+		})
 	case *ast.ArrayLiteral: 
 		elements := evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
@@ -183,8 +197,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.Array{Elements: elements}
 	
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return NewThrownError(val)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.WhileExpression:
 		return evalWhileExpression(node, env)
+	case *ast.ForExpression:
+		return evalForExpression(node, env)
 
 	case *ast.BreakStatement:
 		return &object.Break{}
@@ -201,11 +225,102 @@ func evalAssignmentExpression(ae *ast.AssignmentExpression, env *object.Environm
 	}
 
 	name := ae.Name.Value
-	env.Set(name, val)
+	if ae.Name.Resolved {
+		if typ := env.TypeAt(ae.Name.Depth, ae.Name.Slot); typ != nil && !typecheck.Matches(val, typ) {
+			return newError("type mismatch: cannot assign %s to %s (%s)", val.Type(), name, typ)
+		}
+		if _, ok := env.AssignAt(ae.Name.Depth, ae.Name.Slot, val); !ok {
+			return newError("assignment to undeclared variable: %s", name)
+		}
+		return val
+	}
+
+	if typ := env.TypeOf(name); typ != nil && !typecheck.Matches(val, typ) {
+		return newError("type mismatch: cannot assign %s to %s (%s)", val.Type(), name, typ)
+	}
+	if _, ok := env.Assign(name, val); !ok {
+		return newError("assignment to undeclared variable: %s", name)
+	}
 
 	return val
 }
 
+// the finally block always runs, and replaces the result only when it errors or leaves the enclosing function or loop
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Block, env)
+
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if node.Param.Resolved {
+			catchEnv.SetAt(node.Param.Slot, errorToHash(err))
+		} else {
+			catchEnv.Set(node.Param.Value, errorToHash(err))
+		}
+		result = Eval(node.Catch, catchEnv)
+	}
+
+	if node.Finally != nil {
+		finally := Eval(node.Finally, env)
+		if finally != nil {
+			ft := finally.Type()
+			if ft == object.RETURN_VALUE_OBJ || ft == object.ERROR_OBJ || ft == object.BREAK_OBJ || ft == object.CONTINUE_OBJ {
+				return finally
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+
+	return result
+}
+
+// errorToHash is the value a catch block sees, the thrown value is kept under "value" and is null for runtime errors
+func errorToHash(err *object.Error) *object.Hash {
+	stack := []object.Object{}
+	for _, frame := range err.Stack {
+		stack = append(stack, &object.String{Value: frame.String()})
+	}
+
+	var value object.Object = NULL
+	if err.Value != nil {
+		value = err.Value
+	}
+
+	position := ""
+	if err.Pos.IsValid() {
+		position = err.Pos.String()
+	}
+
+	pairs := make(map[object.HashKey]object.HashPair)
+	for _, pair := range []object.HashPair{
+		{Key: &object.String{Value: "message"}, Value: &object.String{Value: err.Message}},
+		{Key: &object.String{Value: "position"}, Value: &object.String{Value: position}},
+		{Key: &object.String{Value: "stack"}, Value: &object.Array{Elements: stack}},
+		{Key: &object.String{Value: "value"}, Value: value},
+	} {
+		pairs[pair.Key.(*object.String).HashKey()] = pair
+	}
+
+	return &object.Hash{Pairs: pairs}
+}
+
+// thrownMessage uses strings as they are and the "message" of a hash, so a caught error can be thrown again
+func thrownMessage(val object.Object) string {
+	switch val := val.(type) {
+	case *object.String:
+		return val.Value
+	case *object.Hash:
+		pair, ok := val.Pairs[(&object.String{Value: "message"}).HashKey()]
+		if msg, isString := pair.Value.(*object.String); ok && isString {
+			return msg.Value
+		}
+	}
+
+	return val.Inspect()
+}
+
 func evalWhileExpression(node *ast.WhileExpression, env *object.Environment) object.Object {
 	var result object.Object = NULL
 
@@ -243,6 +358,53 @@ func evalWhileExpression(node *ast.WhileExpression, env *object.Environment) obj
 	return result 
 }
 
+// the loop gets its own environment so a `let` in the init clause does not leak into the enclosing scope
+func evalForExpression(node *ast.ForExpression, env *object.Environment) object.Object {
+	var result object.Object = NULL
+
+	loopEnv := object.NewEnclosedEnvironment(env)
+
+	if node.Init != nil {
+		init := Eval(node.Init, loopEnv)
+		if isError(init) {
+			return init
+		}
+	}
+
+	for {
+		if node.Condition != nil {
+			condition := Eval(node.Condition, loopEnv)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				break
+			}
+		}
+
+		result = Eval(node.Body, loopEnv)
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+			if rt == object.BREAK_OBJ {
+				return NULL
+			}
+		}
+
+		if node.Update != nil {
+			update := Eval(node.Update, loopEnv)
+			if isError(update) {
+				return update
+			}
+		}
+	}
+
+	return result
+}
+
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
@@ -282,6 +444,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
         }
         element := arrayObject.Elements[idx]
         return element
+		case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+			return evalStringIndexExpression(left.(*object.String).Value, index.(*object.Integer).Value)
 		case left.Type() == object.HASH_OBJ: 
 			return evalHashIndexExpression(left, index)
     default:
@@ -290,6 +454,149 @@ func evalIndexExpression(left, index object.Object) object.Object {
 }
 
 
+// evalStringIndexExpression is the character at idx, strings are indexed by code point rather than by byte
+func evalStringIndexExpression(str string, idx int64) object.Object {
+	if idx < 0 || idx >= int64(len(str)) { // a string never has more characters than bytes
+		return NULL
+	}
+
+	runes := []rune(str)
+	if idx >= int64(len(runes)) {
+		return NULL
+	}
+	return &object.Char{Value: runes[idx]}
+}
+
+// evalSliceExpression copies the characters of a string or the elements of an array from low up to high, a NULL
+// bound is the start or the end and bounds past either end are moved to it
+func evalSliceExpression(left, low, high object.Object) object.Object {
+	var length int
+	switch left := left.(type) {
+	case *object.Array:
+		length = len(left.Elements)
+	case *object.String:
+		length = utf8.RuneCountInString(left.Value)
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+
+	start, err := sliceBound(low, 0, length)
+	if err != nil {
+		return err
+	}
+	end, err := sliceBound(high, length, length)
+	if err != nil {
+		return err
+	}
+	if start > end {
+		start = end
+	}
+
+	if arr, ok := left.(*object.Array); ok {
+		elements := make([]object.Object, end-start)
+		copy(elements, arr.Elements[start:end])
+		return &object.Array{Elements: elements}
+	}
+	return &object.String{Value: string([]rune(left.(*object.String).Value)[start:end])}
+}
+
+func sliceBound(bound object.Object, def, length int) (int, *object.Error) {
+	switch bound := bound.(type) {
+	case *object.Null:
+		return def, nil
+	case *object.Integer:
+		return int(max(0, min(bound.Value, int64(length)))), nil
+	case *object.BigInt:
+		if bound.Value.Sign() < 0 {
+			return 0, nil
+		}
+		return length, nil
+	default:
+		return 0, newError("slice bounds must be INTEGER, got %s", bound.Type())
+	}
+}
+
+// the container is evaluated first, then the index, then the value, and is updated in place so every
+// reference to it sees the change
+func evalIndexAssignmentExpression(node *ast.IndexAssignmentExpression, env *object.Environment) object.Object {
+	left := Eval(node.Target.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	index := Eval(node.Target.Index, env)
+	if isError(index) {
+		return index
+	}
+
+	val := Eval(node.Value, env)
+	if isError(val) {
+		return val
+	}
+
+	if err := checkElementTypes(declaredType(node.Target.Left, env), node.Target.Left.String(), index, val); err != nil {
+		return err
+	}
+	return evalSetIndex(left, index, val)
+}
+
+// declaredType is the type of a typed variable, or of an element of one reached by indexing it, nil otherwise
+func declaredType(exp ast.Expression, env *object.Environment) *ast.TypeAnnotation {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if exp.Resolved {
+			if exp.Depth < 0 {
+				return nil
+			}
+			return env.TypeAt(exp.Depth, exp.Slot)
+		}
+		return env.TypeOf(exp.Value)
+	case *ast.IndexExpression:
+		return typecheck.ElementType(declaredType(exp.Left, env))
+	default:
+		return nil
+	}
+}
+
+// checkElementTypes is the error for storing val at index in container when it was declared as typ, typed arrays
+// and hashes keep their element types at runtime the same way typed variables keep theirs
+func checkElementTypes(typ *ast.TypeAnnotation, container string, index, val object.Object) *object.Error {
+	switch {
+	case typ == nil:
+		return nil
+	case typ.Name == "hash" && !typecheck.Matches(index, typ.Params[0]):
+		return newError("type mismatch: cannot use %s as key of %s (%s)", index.Type(), container, typ)
+	case typecheck.ElementType(typ) != nil && !typecheck.Matches(val, typecheck.ElementType(typ)):
+		return newError("type mismatch: cannot assign %s to an element of %s (%s)", val.Type(), container, typ)
+	default:
+		return nil
+	}
+}
+
+func evalSetIndex(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d (length %d)", idx.Value, len(left.Elements))
+		}
+		left.Elements[idx.Value] = val
+		return val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return val
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
@@ -307,11 +614,25 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 }
 
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn with args, callPos is where the call happened and is recorded on errors as they unwind.
+// caller is the environment the call was made in, the new call is one deeper than it.
+func applyFunction(fn object.Object, args []object.Object, callPos token.Position, caller *object.Environment) object.Object {
 	switch fn := fn.(type) {
 		case *object.Function:
-			extendedEnv := extendFunctionEnv(fn, args)
-			evaluated := Eval(fn.Body, extendedEnv)
+			required := requiredParameters(fn)
+			if err := checkArity(fn.Name, required, len(fn.Parameters)-required, fn.Rest != nil, len(args)); err != nil {
+				return err
+			}
+			if caller.Depth() >= MaxCallDepth {
+				return newError("stack overflow")
+			}
+			extendedEnv, evaluated := extendFunctionEnv(fn, args, caller.Depth()+1)
+			if evaluated == nil {
+				evaluated = evalBlockStatement(fn.Body, extendedEnv) // the body shares the scope of the parameters
+			}
+			if err, ok := evaluated.(*object.Error); ok {
+				err.Stack = append(err.Stack, object.StackFrame{Function: fn.Name, Pos: callPos})
+			}
 			return unwrapReturnValue(evaluated)			
 		case *object.Builtin: 
 			return fn.Fn(args...)
@@ -320,14 +641,71 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+// checkArity is the error for calling a function that takes required to required+optional arguments with got of them
+func checkArity(name string, required, optional int, rest bool, got int) *object.Error {
+	if got >= required && (rest || got <= required+optional) {
+		return nil
+	}
+
+	want := fmt.Sprint(required)
+	if rest {
+		want = fmt.Sprintf("at least %d", required)
+	} else if optional > 0 {
+		want = fmt.Sprintf("%d to %d", required, required+optional)
+	}
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	return newError("wrong number of arguments to %s: want=%s, got=%d", name, want, got)
+}
+
+// requiredParameters counts the parameters before the first one with a default value
+func requiredParameters(fn *object.Function) int {
+	for i := range fn.Parameters {
+		if i < len(fn.Defaults) && fn.Defaults[i] != nil {
+			return i
+		}
+	}
+	return len(fn.Parameters)
+}
+
+// extendFunctionEnv binds the arguments of a call, then works out the defaults of the parameters that were not
+// passed in the new environment so they can use the ones before them. It returns the error a default raised.
+func extendFunctionEnv(fn *object.Function, args []object.Object, depth int) (*object.Environment, object.Object) {
+	env := object.NewSizedEnvironment(fn.Env, fn.Locals, depth)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if paramIdx < len(args) {
+			bindParameter(env, param, args[paramIdx])
+		}
+	}
+
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		bindParameter(env, fn.Rest, &object.Array{Elements: rest})
+	}
+
+	for paramIdx := len(args); paramIdx < len(fn.Parameters); paramIdx++ {
+		val := Eval(fn.Defaults[paramIdx], env)
+		if isError(val) {
+			return env, val
+		}
+		bindParameter(env, fn.Parameters[paramIdx], val)
 	}
 
-	return env
+	return env, nil
+}
+
+func bindParameter(env *object.Environment, param *ast.Identifier, val object.Object) {
+	if param.Resolved {
+		env.SetAt(param.Slot, val)
+	} else {
+		env.Set(param.Value, val)
+	}
 }
 
 
@@ -370,6 +748,33 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
+// evalInterpolatedString joins the text of the string with how each embedded value prints
+func evalInterpolatedString(is *ast.InterpolatedString, env *object.Environment) object.Object {
+	parts := []object.Object{}
+
+	for i, text := range is.Strings {
+		parts = append(parts, &object.String{Value: text})
+
+		if i < len(is.Expressions) {
+			val := Eval(is.Expressions[i], env)
+			if isError(val) {
+				return val
+			}
+			parts = append(parts, val)
+		}
+	}
+
+	return interpolate(parts)
+}
+
+func interpolate(parts []object.Object) *object.String {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(part.Inspect())
+	}
+	return &object.String{Value: out.String()}
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
 	if isError(condition) {
@@ -386,6 +791,16 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if node.Resolved {
+		if node.Depth < 0 {
+			return builtins[node.Value]
+		}
+		if val, ok := env.GetAt(node.Depth, node.Slot); ok {
+			return val
+		}
+		return newError("identifier not found: " + node.Value)
+	}
+
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
@@ -417,24 +832,117 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		result, ok := addInt(leftVal, rightVal)
+		return integerResult(result, ok, operator, left, right)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		result, ok := subInt(leftVal, rightVal)
+		return integerResult(result, ok, operator, left, right)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		result, ok := divInt(leftVal, rightVal)
+		return integerResult(result, ok, operator, left, right)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		result, ok := mulInt(leftVal, rightVal)
+		return integerResult(result, ok, operator, left, right)
+	case "%":
+		if rightVal == 0 {
+			return newError("modulo by zero")
+		}
+		if rightVal == -1 { // the remainder is always 0, but Go can trap on math.MinInt64 % -1
+			return &object.Integer{Value: 0}
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			result, ok := shlInt(leftVal, rightVal)
+			return integerResult(result, ok, operator, left, right)
+		}
+		return &object.Integer{Value: leftVal >> uint64(rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+
+	}
+}
+
+// integerResult is the result of a checked operation, when it did not fit the operation is done again on big integers
+func integerResult(result int64, ok bool, operator string, left, right object.Object) object.Object {
+	if !ok {
+		return evalBigIntInfixExpression(operator, left, right)
+	}
+	return &object.Integer{Value: result}
+}
+
+// evalFloatInfixExpression handles float operands and mixed int/float operands, integers are widened to floats
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
 
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
 	}
 }
 
@@ -442,8 +950,16 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 	switch {
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
+	case left.Type() == object.CHAR_OBJ && right.Type() == object.CHAR_OBJ:
+		return evalCharInfixExpression(operator, left, right)
+	case operator == "+" && isText(left) && isText(right): // a char and a string concatenate like two strings
+		return &object.String{Value: textValue(left) + textValue(right)}
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isInteger(left) && isInteger(right):
+		return evalBigIntInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
@@ -467,25 +983,99 @@ func evalStringInfixExpression(operator string, left object.Object, right object
 
 }
 
+func evalCharInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := left.(*object.Char).Value
+	rightVal := right.(*object.Char).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: string(leftVal) + string(rightVal)}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isText(obj object.Object) bool {
+	return obj.Type() == object.STRING_OBJ || obj.Type() == object.CHAR_OBJ
+}
+
+func textValue(obj object.Object) string {
+	if c, ok := obj.(*object.Char); ok {
+		return string(c.Value)
+	}
+	return obj.(*object.String).Value
+}
+
+// && and || only evaluate their right operand when the left one does not already decide the result
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		switch right := right.(type) {
+		case *object.Integer:
+			return &object.Integer{Value: ^right.Value}
+		case *object.BigInt:
+			return normalizeInt(new(big.Int).Not(right.Value))
+		default:
+			return newError("unknown operator: ~%s", right.Type())
+		}
 	default:
 		return newError("unkown operator: %s%s", operator, right.Type())
 	}
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		value, ok := negInt(right.Value)
+		if !ok {
+			return normalizeInt(new(big.Int).Neg(big.NewInt(right.Value)))
+		}
+		return &object.Integer{Value: value}
+	case *object.BigInt:
+		return normalizeInt(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
-
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -521,8 +1111,11 @@ func evalProgram(statements []ast.Statement, env *object.Environment) object.Obj
 	for _, statement := range statements {
 		result = Eval(statement, env)
 
-		if returnValue, ok := result.(*object.ReturnValue); ok {
-			return returnValue.Value
+		switch result := result.(type) {
+		case *object.ReturnValue:
+			return result.Value
+		case *object.Error:
+			return result
 		}
 	}
 
@@ -1,9 +1,13 @@
 package evaluator
 
 import (
+	"APE/ast"
 	"APE/lexer"
 	"APE/object"
 	"APE/parser"
+	"APE/resolver"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -112,7 +116,47 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestBuiltinTable(t *testing.T) {
+	names := BuiltinNames()
+	if len(names) != len(builtins) {
+		t.Fatalf("wrong number of builtin names. want=%d, got=%d", len(builtins), len(names))
+	}
+
+	for i, name := range names {
+		if i > 0 && names[i-1] >= name {
+			t.Errorf("builtin names out of order: %q before %q", names[i-1], name)
+		}
+		if index, ok := BuiltinIndex(name); !ok || index != i {
+			t.Errorf("wrong index for %s. want=%d, got=%d (%t)", name, i, index, ok)
+		}
+		if BuiltinAt(i) != builtins[name] {
+			t.Errorf("BuiltinAt(%d) is not %s", i, name)
+		}
+	}
 
+	if _, ok := BuiltinIndex("missing"); ok {
+		t.Errorf("expected no index for an unknown builtin")
+	}
+}
+
+
+func TestForExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected int64
+	}{
+		{"let x = 0; for (let i = 0; i < 5; i = i + 1) { x = x + i; }; x;", 10},
+		{"let x = 0; for (let i = 0; i < 10; i = i + 1) { if (i == 3) { break; }; x = x + 1; }; x;", 3},
+		{"let x = 0; for (let i = 0; i < 5; i = i + 1) { if (i == 2) { continue; }; x = x + i; }; x;", 8},
+		{"let f = fn() { for (let i = 0; i < 10; i = i + 1) { if (i == 4) { return i; }; }; 99; }; f();", 4},
+		{"let i = 0; for (; i < 3;) { i = i + 1; }; i;", 3},
+		{"let i = 0; for (i = 5; i < 7; i = i + 1) { }; i;", 7},
+		{"let i = 42; for (let i = 0; i < 3; i = i + 1) { }; i;", 42},
+		{"let x = 0; for (;;) { x = x + 1; if (x == 6) { break; }; }; x;", 6},
+		{"let f = fn() { for (let i = 0; ; i = i + 1) { if (i == 3) { break; }; }; 7; }; f();", 7},
+		{"let f = fn() { for (let i = 0; i < 10; i = i + 1) { if (i == 2) { continue; }; if (i > 5) { return i * 2; }; }; 99; }; f();", 12},
+		{"let f = fn() { for (;;) { return 5; }; }; f();", 5},
+	}
 
 	for i, tt  := range tests {
 		evaluated := testEval(tt.input)
@@ -331,6 +375,43 @@ func TestStringConcatenation(t *testing.T) {
 }
 
 
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let user = {"name": "ada"}; let n = 3; "hello ${user["name"]}, you have ${n * 2} items"`, "hello ada, you have 6 items"},
+		{`"${1.5} ${true} ${[1, "a"]} ${'c'}"`, "1.5 true [1, a] c"},
+		{`"${"${1 + 1}"}"`, "2"},
+		{`let f = fn(x) { "<${x}>" }; f("a") + f(1)`, "<a><1>"},
+		{`"cost: \${5}"`, "cost: ${5}"},
+		{`"${1 / 0}"`, "division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				if err.Message != expected {
+					t.Errorf("%s - wrong error message. expected=%q, got=%q", tt.input, expected, err.Message)
+				}
+				continue
+			}
+
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%s - object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("%s - String has wrong value. want=%q, got=%q", tt.input, expected, str.Value)
+			}
+		}
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
@@ -472,6 +553,7 @@ func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	resolver.New(BuiltinNames()).Resolve(program) // names it cannot resolve are left to fail at runtime
 	env := object.NewEnvironment()
 	return Eval(program, env)
 }
@@ -645,6 +727,26 @@ func TestHashIndexExpressions(t *testing.T) {
 		`{false: 5}[false]`, 
 		5,
 	},
+	{
+		`{1: 5}[1.0]`,
+		5,
+	},
+	{
+		`{2.0: 5}[2]`,
+		5,
+	},
+	{
+		`{-0.0: 5}[0]`,
+		5,
+	},
+	{
+		`{100000000000000000000: 5}[1e20]`,
+		5,
+	},
+	{
+		`{1: 5}[1.5]`,
+		nil,
+	},
 	}
 
 	for _, tt := range tests {
@@ -658,3 +760,850 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+
+func TestForLoopVariableScope(t *testing.T) {
+	input := "for (let i = 0; i < 3; i = i + 1) { }; i;"
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if errObj.Message != "identifier not found: i" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"let a = 1;\nlet b = a + x;", "ERROR: hello.ape:2:13: identifier not found: x"},
+		{"let f = fn() {\n  true + 1;\n};\nf();", "ERROR: hello.ape:2:8: operator mismatch: BOOLEAN + INTEGER"},
+		{"\n  len(1)", "ERROR: hello.ape:2:6: argument to `len` not supported, got=INTEGER"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile("hello.ape", tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		evaluated := Eval(program, object.NewEnvironment())
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Inspect())
+		}
+	}
+}
+
+func TestErrorStopsProgram(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{`-true; "after"`, "unknown operator: -BOOLEAN"},
+		{`let f = fn() { -true }; f(); "after"`, "unknown operator: -BOOLEAN"},
+		{`let x = 1; -true; let x = 2; x`, "unknown operator: -BOOLEAN"},
+		{`1 / 0; "after"`, "division by zero"},
+		{`let f = fn() { "x" }; let a: array<int> = [f()]; a`, "type mismatch: cannot assign ARRAY to a (array<int>)"},
+		{`let x = 1; x + true; x = 2; x`, "operator mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s - object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expected {
+			t.Errorf("%s - wrong error message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let inner = fn(a) {
+  a + missing;
+};
+let outer = fn() {
+  inner(1);
+};
+let run = fn() { outer() };
+fn() { run() }();`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := []string{
+		"at inner (5:8)",
+		"at outer (7:23)",
+		"at run (8:11)",
+		"at <anonymous> (8:15)",
+	}
+
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack depth. expected=%d, got=%d", len(expected), len(errObj.Stack))
+	}
+
+	for i, frame := range errObj.Stack {
+		if frame.String() != expected[i] {
+			t.Errorf("stack[%d] wrong. expected=%q, got=%q", i, expected[i], frame.String())
+		}
+	}
+}
+
+func TestTryCatchExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw "boom"; 1 } catch (e) { 2 }`, 2},
+		{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+		{`try { throw 42 } catch (e) { e["value"] }`, 42},
+		{`try { {"a": 1}["a"] + true } catch (e) { e["message"] }`, "operator mismatch: INTEGER + BOOLEAN"},
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got=INTEGER"},
+		{`try { missing } catch (e) { e["position"] }`, "1:7"},
+		{`let f = fn() { throw "deep" }; let g = fn() { f() }; try { g() } catch (e) { first(e["stack"]) + " " + last(e["stack"]) }`, "at f (1:48) at g (1:61)"},
+		{`let x = 0; try { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let x = 0; try { throw "a" } catch (e) { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let x = 0; try { throw "a" } catch (e) { 1 } finally { x = x + 10 }; x`, 10},
+		{`let f = fn() { try { return 1 } finally { 2 } }; f()`, 1},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+		{`try { try { throw "inner" } finally { 1 } } catch (e) { e["message"] + "!" }`, "inner!"},
+		{`let x = 0; for (let i = 0; i < 5; i = i + 1) { try { if (i == 3) { break; }; x = x + 1 } catch (e) { 0 } }; x`, 3},
+		{`let f = fn() { for (let i = 0; i < 5; i = i + 1) { try { if (i == 3) { return i; } } catch (e) { 0 } }; 99 }; f()`, 3},
+		{`let f = fn() { for (;;) { try { break; } catch (e) { 0 } }; 7 }; f()`, 7},
+		{`throw "uncaught"`, "ERROR: 1:1: uncaught"},
+		{`throw {"message": "custom", "code": 7}`, "ERROR: 1:1: custom"},
+	}
+
+	for i, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Inspect() != expected {
+					t.Errorf("test[%d] - wrong error. expected=%q, got=%q", i, expected, errObj.Inspect())
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 / 4.0", 2.5},
+		{"2 * 1e3", 2000},
+		{"(1 + 2 + 3) / 3.0", 2.0},
+		{"float(7) / 2", 3.5},
+		{`float("2.25")`, 2.25},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestMixedNumberComparison(t *testing.T) {
+	tests := []struct {
+		input string
+		expected bool
+	}{
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1 != 1.5", true},
+		{"0.1 + 0.2 == 0.3", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestNumberConversionBuiltins(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{"int(5)", 5},
+		{`int("42")`, 42},
+		{`int("4.2")`, `cannot convert "4.2" to an integer`},
+		{`int(true)`, "argument to `int` not supported, got=BOOLEAN"},
+		{`float("abc")`, `cannot convert "abc" to a float`},
+		{`float(1, 2)`, "wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func TestExtendedOperators(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"7 % 3", 7 % 3},
+		{"-7 % 3", -7 % 3},
+		{"6 & 3", 6 & 3},
+		{"6 | 3", 6 | 3},
+		{"6 ^ 3", 6 ^ 3},
+		{"~5", ^5},
+		{"1 << 10", 1 << 10},
+		{"-16 >> 2", -16 >> 2},
+		{"1 + 2 << 3", (1 + 2) << 3},
+		{"5 & 1 == 1", true},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 >= 1", true},
+		{"2.5 <= 2", false},
+		{"true && false", false},
+		{"true && true", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 3 > 2", true},
+		{"false && missing", false},
+		{"true || missing", true},
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x", 0},
+		{"let x = 0; false && missing(); x", 0},
+		{"let x = 0; let f = fn() { x = 1; true }; true && f(); x", 1},
+		{"let f = fn() { true }; if (true && f()) { 1 } else { 0 }", 1},
+		{"1 << -1", "negative shift count: -1"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"~true", "unknown operator: ~BOOLEAN"},
+		{"true && missing", "identifier not found: missing"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestFloatModulo(t *testing.T) {
+	testFloatObject(t, testEval("7.5 % 2"), 1.5)
+}
+
+func TestTypedLetStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"let x: int = 7; x", 7},
+		{"let x: int = 7; x = 8; x", 8},
+		{"let f: fn = fn(a) { a * 2 }; f(4)", 8},
+		{"let nums: array<int> = [1, 2]; nums = [3]; nums[0]", 3},
+		{"let x: any = 1; x = \"now a string\"; len(x)", 12},
+		{"let x: int = 1; let x = \"a\"; x = true; x", true},
+		{"let x: int = 1; let set = fn() { x = 5 }; set(); x", 5},
+		{"let x: int = 1; let set = fn() { x = 5 }; set()", 5},
+		{"let x: int = 1; let f = fn(x) { x = \"s\"; 2 }; f(0)", 2},
+		{`let g = fn() { "s" }; let x: int = g();`, "type mismatch: cannot assign STRING to x (int)"},
+		{`let x: int = 1; let g = fn() { "s" }; x = g();`, "type mismatch: cannot assign STRING to x (int)"},
+		{`let x: int = 7; let g = fn() { "s" }; x = g(); x`, "type mismatch: cannot assign STRING to x (int)"},
+		{`let x: int = 7; let g = fn() { "s" }; if (true) { x = g(); }; x`, "type mismatch: cannot assign STRING to x (int)"},
+		{`let x: int = 1; let set = fn(v) { x = v }; set(1.5)`, "type mismatch: cannot assign FLOAT to x (int)"},
+		{`let g = fn() { [1, "a"] }; let nums: array<int> = g();`, "type mismatch: cannot assign ARRAY to nums (array<int>)"},
+		{`let a: array<int> = [1]; let f = fn() { 2 }; a[0] = f(); a[0]`, 2},
+		{`let a: array<int> = [1]; let f = fn() { "x" }; a[0] = f(); a`, "type mismatch: cannot assign STRING to an element of a (array<int>)"},
+		{`let h: hash<string, int> = {"a": 1}; let f = fn() { 1 }; h[f()] = 2; h`, "type mismatch: cannot use INTEGER as key of h (hash<string, int>)"},
+		{`let h: hash<string, int> = {"a": 1}; let f = fn() { true }; h["b"] = f(); h`, "type mismatch: cannot assign BOOLEAN to an element of h (hash<string, int>)"},
+		{`let m: array<array<int>> = [[1]]; let f = fn() { "x" }; m[0][0] = f(); m`, "type mismatch: cannot assign STRING to an element of (m[0]) (array<int>)"},
+		{`let a: array<int> = [1]; let set = fn(v) { a[0] = v }; set(1.5)`, "type mismatch: cannot assign FLOAT to an element of a (array<int>)"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestCharExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"'a'", 'a'},
+		{"'a' == 'a'", true},
+		{"'a' != 'b'", true},
+		{"'a' < 'b'", true},
+		{"'z' >= 'a'", true},
+		{"'a' + 'b'", "ab"},
+		{`'a' + "pe"`, "ape"},
+		{`"ap" + 'e'`, "ape"},
+		{"ord('A')", 65},
+		{"ord('é')", 233},
+		{"chr(97)", 'a'},
+		{"chr(ord('a') + 1)", 'b'},
+		{`{'a': 1, 'b': 2}['b']`, 2},
+		{"let c: char = 'c'; c", 'c'},
+		{"'a' - 'b'", "unknown operator: CHAR - CHAR"},
+		{`ord("a")`, "argument to `ord` must be CHAR, got STRING"},
+		{"chr(-1)", "-1 is not a valid character code"},
+		{"chr(55296)", "55296 is not a valid character code"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case rune:
+			char, ok := evaluated.(*object.Char)
+			if !ok {
+				t.Errorf("%s - object is not Char. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if char.Value != expected {
+				t.Errorf("%s - wrong value. expected=%q, got=%q", tt.input, expected, char.Value)
+			}
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%s - wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestUnicodeStrings(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{`len("héllo")`, 5},
+		{`len("👋🌍")`, 2},
+		{`let größe = "日本語"; größe[1]`, '本'},
+		{`"héllo"[4]`, 'o'},
+		{`"héllo"[5]`, nil},
+		{`"héllo"[-1]`, nil},
+		{`"héllo"[1:3]`, "él"},
+		{`"héllo"[:2]`, "hé"},
+		{`"héllo"[3:]`, "lo"},
+		{`"héllo"[:]`, "héllo"},
+		{`"héllo"[4:2]`, ""},
+		{`"héllo"[-5:100]`, "héllo"},
+		{`bytes("hé")`, []int{104, 195, 169}},
+		{`len("hé") + bytes("hé").reduce(fn(n, b) { n + 1 }, 0)`, 5},
+		{`[1, 2, 3, 4][1:3]`, []int{2, 3}},
+		{`[1, 2, 3][2:]`, []int{3}},
+		{`[1, 2, 3][5:]`, []int{}},
+		{`let a = [1, 2]; let b = a[:]; b[0] = 9; a[0]`, 1},
+		{`"abc"["a":]`, "slice bounds must be INTEGER, got STRING"},
+		{`5[1:2]`, "slice operator not supported: INTEGER"},
+		{`bytes(1)`, "argument to `bytes` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case rune:
+			char, ok := evaluated.(*object.Char)
+			if !ok {
+				t.Errorf("%s - object is not Char. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if char.Value != expected {
+				t.Errorf("%s - wrong value. expected=%q, got=%q", tt.input, expected, char.Value)
+			}
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []int:
+			arr, ok := evaluated.(*object.Array)
+			if !ok || len(arr.Elements) != len(expected) {
+				t.Errorf("%s - wrong array. expected=%v, got=%s", tt.input, expected, evaluated.Inspect())
+				continue
+			}
+			for i, e := range expected {
+				testIntegerObject(t, arr.Elements[i], int64(e))
+			}
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%s - wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{`"Hello".upper()`, "HELLO"},
+		{`"a,b,,c".split(",")`, []string{"a", "b", "", "c"}},
+		{`"héllo".split("")`, []string{"h", "é", "l", "l", "o"}},
+		{`"a b".split(' ')`, []string{"a", "b"}},
+		{`"  padded \n".trim()`, "padded"},
+		{`"monkey".contains("key")`, true},
+		{`"monkey".contains('z')`, false},
+		{`"a-b-c".replace("-", "+")`, "a+b+c"},
+		{`{"b": 2, "a": 1, "c": 3}.keys()`, []string{"a", "b", "c"}},
+		{`{10: "x", 9: "y", -1: "z"}.keys()`, []int{-1, 9, 10}},
+		{`{"b": 2, "a": 1, "c": 3}.values()`, []int{1, 2, 3}},
+		{`{}.keys()`, []int{}},
+		{`let h = {"a": 1}; [h.has("a"), h.has("b")]`, []bool{true, false}},
+		{`let h = {"a": 1, "b": 2}; h.delete("a") + h.values()[0]`, 3},
+		{`let h = {"a": 1}; h.delete("missing")`, nil},
+		{`let h = {"a": 1}; h.delete("a"); h.keys()`, []int{}},
+		{`let a = {"x": 1, "y": 2}; let b = a.merge({"y": 20, "z": 30}); b.values()`, []int{1, 20, 30}},
+		{`let a = {"x": 1}; a.merge({"y": 2}); a.keys()`, []string{"x"}},
+		{`(-5).abs()`, 5},
+		{`7.abs()`, 7},
+		{`(-9223372036854775807 - 1).abs() - 9223372036854775807`, 1},
+		{`2.pow(10)`, 1024},
+		{`(-3).pow(3)`, -27},
+		{`5.pow(0)`, 1},
+		{`2.pow(64) / 2.pow(60)`, 16},
+		{`["a", "bb", "ccc"].map(len)`, []int{1, 2, 3}},
+		{`["1", "2"].map(int).reduce(fn(a, b) { a + b }, 0)`, 3},
+		{`[[1], [], [2, 3]].filter(first).map(first)`, []int{1, 2}},
+		{`[1, 2].reduce(push, [])`, []int{1, 2}},
+		{`true.not()`, "unknown method not for BOOLEAN"},
+		{`"a".lower()`, "unknown method lower for STRING"},
+		{`"a".split()`, "wrong number of arguments to split: want=1, got=0"},
+		{`"a".split(1)`, "argument to split must be STRING, got INTEGER"},
+		{`{}.has([1])`, "unusable as hash key: ARRAY"},
+		{`{}.merge([1])`, "argument to merge must be HASH, got ARRAY"},
+		{`2.pow(-1)`, "negative exponent: -1"},
+		{`2.pow(1.5)`, "argument to pow must be INTEGER, got FLOAT"},
+		{`3.pow(10000000)`, "exponent too large: 10000000"},
+		{`[1].map(5)`, "argument to map must be a function"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case []int:
+			testIntegerArray(t, evaluated, expected)
+		case []string:
+			testStringArray(t, evaluated, expected)
+		case []bool:
+			arr, ok := evaluated.(*object.Array)
+			if !ok || len(arr.Elements) != len(expected) {
+				t.Errorf("%s - wrong array. expected=%v, got=%s", tt.input, expected, evaluated.Inspect())
+				continue
+			}
+			for i, e := range expected {
+				testBooleanObject(t, arr.Elements[i], e)
+			}
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected {
+					t.Errorf("%s - wrong error message. expected=%q, got=%q", tt.input, expected, errObj.Message)
+				}
+				continue
+			}
+			testStringObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[1] = 20; a[1]", 20},
+		{"let a = [1, 2, 3]; a[0] = a[1] + a[2]; a[0]", 5},
+		{"let a = [1, 2, 3]; let b = a; a[2] = 30; b[2]", 30},
+		{"let a = [1]; (a[0] = 7) + 1", 8},
+		{`let h = {"a": 1}; h["a"] = 2; h["a"]`, 2},
+		{`let h = {}; h["new"] = 3; h["new"]`, 3},
+		{`let h = {"a": [1, 2]}; h["a"][0] = 10; h["a"][0]`, 10},
+		{`let grid = [[0, 0], [0, 0]]; grid[1][0] = 4; grid[1][0] + grid[0][0]`, 4},
+		{`let set = fn(arr) { arr[0] = 99 }; let a = [1]; set(a); a[0]`, 99},
+		{"let a = [1, 2, 3]; a[3] = 4", "index out of range: 3 (length 3)"},
+		{"let a = [1, 2, 3]; a[-1] = 4", "index out of range: -1 (length 3)"},
+		{`let a = [1]; a["x"] = 1`, "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[[1]] = 1", "unusable as hash key: ARRAY"},
+		{`let s = "abc"; s[0] = 'x'`, "index assignment not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestAssignmentUpdatesDefiningScope(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"let count = 0; let inc = fn() { count = count + 1 }; inc(); inc(); count", 2},
+		{"let counter = fn() { let c = 0; fn() { c = c + 1 } }; let f = counter(); f(); f(); f()", 3},
+		{"let counter = fn() { let c = 0; fn() { c = c + 1 } }; let a = counter(); let b = counter(); a(); a(); b()", 1},
+		{"let make = fn() { let n = 0; let get = fn() { n }; let inc = fn() { n = n + 10 }; inc(); inc(); get() }; make()", 20},
+		{"let x = 1; let f = fn() { let x = 5; x = x + 1; x }; f() + x", 7},
+		{"let x = 1; let f = fn(x) { x = 100 }; f(0); x", 1},
+		{"let total = 0; for (let i = 0; i < 4; i = i + 1) { total = total + i }; total", 6},
+		{"let f = fn() { late = 2 }; let late = 1; f(); late", 2},
+		{"y = 5", "assignment to undeclared variable: y"},
+		{"let f = fn() { nope = 1 }; f()", "assignment to undeclared variable: nope"},
+		{"for (let i = 0; i < 1; i = i + 1) { let inner = 1 }; i = 2", "assignment to undeclared variable: i"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestBlockScoping(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"let x = 1; if (true) { let x = 2; x }", 2},
+		{"let x = 1; if (true) { let x = 2 }; x", 1},
+		{"let x = 1; if (false) { 0 } else { let x = 3 }; x", 1},
+		{"let x = 1; let i = 0; while (i < 3) { let x = i; i = i + 1 }; x", 1},
+		{"let x = 1; if (true) { x = 5 }; x", 5},
+		{"let x = 1; if (true) { let x = 2; x = 3 }; x", 1},
+		{"let x = 1; if (true) { if (true) { let x = 9 }; x = x + 1 }; x", 2},
+		{"let f = fn() { let x = 1; if (true) { let x = 2 }; x }; f()", 1},
+		{"if (true) { let inner = 1 }; inner", "identifier not found: inner"},
+		{"let i = 0; while (i < 2) { let seen = i; i = i + 1 }; seen", "identifier not found: seen"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+// BenchmarkFibonacci compares looking variables up by name through every scope with the slots the resolver assigns
+func BenchmarkFibonacci(b *testing.B) {
+	input := `
+let fibonacci = fn(x) {
+	if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+fibonacci(20);
+`
+	b.Run("names", func(b *testing.B) {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		for i := 0; i < b.N; i++ {
+			Eval(program, object.NewEnvironment())
+		}
+	})
+
+	b.Run("slots", func(b *testing.B) {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		resolver.New(BuiltinNames()).Resolve(program)
+		for i := 0; i < b.N; i++ {
+			Eval(program, object.NewEnvironment())
+		}
+	})
+}
+
+func TestFunctionArity(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"let add = fn(a, b) { a + b }; add(1)", "wrong number of arguments to add: want=2, got=1"},
+		{"let add = fn(a, b) { a + b }; add(1, 2, 3)", "wrong number of arguments to add: want=2, got=3"},
+		{"fn(a) { a }()", "wrong number of arguments to <anonymous>: want=1, got=0"},
+		{"let f = fn(a, b = 2) { a + b }; f(1)", 3},
+		{"let f = fn(a, b = 2) { a + b }; f(1, 5)", 6},
+		{"let f = fn(a, b = 2) { a + b }; f()", "wrong number of arguments to f: want=1 to 2, got=0"},
+		{"let f = fn(a, b = 2) { a + b }; f(1, 2, 3)", "wrong number of arguments to f: want=1 to 2, got=3"},
+		{"let f = fn(a, b = a * 10) { b }; f(4)", 40},
+		{"let n = 1; let f = fn(a = n) { a }; n = 5; f()", 5},
+		{"let f = fn(a, b = missing) { a }; f(1, 2)", 1},
+		{"let f = fn(a, b = missing) { a }; f(1)", "identifier not found: missing"},
+		{"let f = fn(first, ...rest) { rest.reduce(fn(n, x) { n + 1 }, 0) }; f(1)", 0},
+		{"let f = fn(first, ...rest) { rest[1] }; f(1, 2, 3)", 3},
+		{"let f = fn(first, ...rest) { first }; f()", "wrong number of arguments to f: want=at least 1, got=0"},
+		{"let f = fn(a, b = 10, ...rest) { a + b + rest[0] }; f(1, 2, 3)", 6},
+		{"let sum = fn(...nums) { nums.reduce(fn(acc, x) { acc + x }, 0) }; sum(1, 2, 3, 4)", 10},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestIntegerArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"1 / 0", "division by zero"},
+		{"let n = 0; 10 % n", "modulo by zero"},
+		{"99999999999999999999 / 0", "division by zero"},
+		{"99999999999999999999 % 0", "modulo by zero"},
+		{"1 << 99999999999", "shift count too large: 99999999999"},
+		{"(-9223372036854775807 - 1) % -1", 0},
+		{"9223372036854775806 + 1", 9223372036854775807},
+		{"-4611686018427387904 * 2", -9223372036854775808},
+		{"-1 << 63", -9223372036854775808},
+		{"let f = fn(n) { f(n + 1) }; f(0)", "stack overflow"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+// each evaluation counts its own calls, so programs running side by side do not add up to a stack overflow
+func TestCallDepthPerEvaluation(t *testing.T) {
+	input := "let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10000)"
+
+	var wg sync.WaitGroup
+	results := make([]object.Object, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = testEval(input)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		testIntegerObject(t, result, 0)
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+		expectedType object.ObjectType
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808", object.BIGINT_OBJ},
+		{"-9223372036854775807 - 2", "-9223372036854775809", object.BIGINT_OBJ},
+		{"4611686018427387904 * 2", "9223372036854775808", object.BIGINT_OBJ},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808", object.BIGINT_OBJ},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808", object.BIGINT_OBJ},
+		{"1 << 64", "18446744073709551616", object.BIGINT_OBJ},
+		{"123456789012345678901234567890", "123456789012345678901234567890", object.BIGINT_OBJ},
+		{"123456789012345678901234567890 * 10 + 5", "1234567890123456789012345678905", object.BIGINT_OBJ},
+		{"-9223372036854775808", "-9223372036854775808", object.INTEGER_OBJ},
+		{"9223372036854775808 - 1", "9223372036854775807", object.INTEGER_OBJ},
+		{"(9223372036854775807 + 1) / 2", "4611686018427387904", object.INTEGER_OBJ},
+		{"-(-(9223372036854775807 + 1))", "9223372036854775808", object.BIGINT_OBJ},
+		{"-99999999999999999999 / 7", "-14285714285714285714", object.BIGINT_OBJ},
+		{"-99999999999999999999 % 7", "-1", object.INTEGER_OBJ},
+		{"~99999999999999999999", "-100000000000000000000", object.BIGINT_OBJ},
+		{"(1 << 70) >> 69", "2", object.INTEGER_OBJ},
+		{"99999999999999999999 > 1", "true", object.BOOLEAN_OBJ},
+		{"-99999999999999999999 < 5", "true", object.BOOLEAN_OBJ},
+		{"99999999999999999999 == 99999999999999999999", "true", object.BOOLEAN_OBJ},
+		{"99999999999999999999 != 99999999999999999998", "true", object.BOOLEAN_OBJ},
+		{"99999999999999999999 + 0.5", "1e+20", object.FLOAT_OBJ},
+		{`{99999999999999999999: "big"}[99999999999999999998 + 1]`, "big", object.STRING_OBJ},
+		{`{9223372036854775807: "small"}[9223372036854775808 - 1]`, "small", object.STRING_OBJ},
+		{"let total: int = 9223372036854775807; total = total * 3; total", "27670116110564327421", object.BIGINT_OBJ},
+		{"int(\"99999999999999999999\") + 1", "100000000000000000000", object.BIGINT_OBJ},
+		{"int(1e20)", "100000000000000000000", object.BIGINT_OBJ},
+		{"float(99999999999999999999)", "1e+20", object.FLOAT_OBJ},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if evaluated.Type() != tt.expectedType || evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - wrong result. want=%s (%s), got=%s (%s)", tt.input, tt.expected, tt.expectedType, evaluated.Inspect(), evaluated.Type())
+		}
+	}
+}
+
+func TestEvalSafelyRecoversPanics(t *testing.T) {
+	broken := &ast.IndexAssignmentExpression{} // no target, evaluating it dereferences nil
+
+	errObj, ok := EvalSafely(broken, object.NewEnvironment()).(*object.Error)
+	if !ok {
+		t.Fatalf("panic was not turned into an error")
+	}
+	if !strings.HasPrefix(errObj.Message, "internal error: ") {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	testIntegerObject(t, testEval("let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(10)"), 0)
+}
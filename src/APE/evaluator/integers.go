@@ -0,0 +1,137 @@
+package evaluator
+
+import (
+	"APE/object"
+	"math"
+	"math/big"
+)
+
+// maxShift is the largest left shift of a big integer, beyond it the result would take up too much memory
+const maxShift = 1 << 20
+
+// Checked int64 arithmetic, each operation reports false when the exact result does not fit in an int64.
+
+func addInt(a, b int64) (int64, bool) {
+	r := a + b
+	return r, (b >= 0) == (r >= a)
+}
+
+func subInt(a, b int64) (int64, bool) {
+	r := a - b
+	return r, (b >= 0) == (r <= a)
+}
+
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	r := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) || r/b != a {
+		return r, false
+	}
+	return r, true
+}
+
+// divInt expects b to be non-zero, the only quotient that overflows is math.MinInt64 / -1
+func divInt(a, b int64) (int64, bool) {
+	if a == math.MinInt64 && b == -1 {
+		return a, false
+	}
+	return a / b, true
+}
+
+func negInt(a int64) (int64, bool) {
+	return -a, a != math.MinInt64
+}
+
+// shlInt expects a non-negative shift count
+func shlInt(a, n int64) (int64, bool) {
+	if n >= 64 {
+		return 0, a == 0
+	}
+	r := a << uint64(n)
+	return r, r>>uint64(n) == a
+}
+
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+}
+
+func toBig(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInt:
+		return obj.Value
+	default:
+		return new(big.Int)
+	}
+}
+
+// normalizeInt is v as an Integer when it fits in one, and as a BigInt otherwise
+func normalizeInt(v *big.Int) object.Object {
+	if v.IsInt64() {
+		return &object.Integer{Value: v.Int64()}
+	}
+	return &object.BigInt{Value: v}
+}
+
+// evalBigIntInfixExpression handles integers when either operand, or the result, does not fit in an int64
+func evalBigIntInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := toBig(left)
+	rightVal := toBig(right)
+	result := new(big.Int)
+
+	switch operator {
+	case "+":
+		return normalizeInt(result.Add(leftVal, rightVal))
+	case "-":
+		return normalizeInt(result.Sub(leftVal, rightVal))
+	case "*":
+		return normalizeInt(result.Mul(leftVal, rightVal))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return normalizeInt(result.Quo(leftVal, rightVal)) // Quo and Rem truncate like int64 division does
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newError("modulo by zero")
+		}
+		return normalizeInt(result.Rem(leftVal, rightVal))
+	case "&":
+		return normalizeInt(result.And(leftVal, rightVal))
+	case "|":
+		return normalizeInt(result.Or(leftVal, rightVal))
+	case "^":
+		return normalizeInt(result.Xor(leftVal, rightVal))
+	case "<<", ">>":
+		if rightVal.Sign() < 0 {
+			return newError("negative shift count: %s", rightVal)
+		}
+		if operator == ">>" {
+			if !rightVal.IsInt64() { // shifts every bit out
+				return normalizeInt(result.Rsh(leftVal, math.MaxUint32))
+			}
+			return normalizeInt(result.Rsh(leftVal, uint(rightVal.Int64())))
+		}
+		if !rightVal.IsInt64() || rightVal.Int64() > maxShift {
+			return newError("shift count too large: %s", rightVal)
+		}
+		return normalizeInt(result.Lsh(leftVal, uint(rightVal.Int64())))
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
@@ -0,0 +1,290 @@
+package evaluator
+
+import (
+	"APE/object"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// applyFunc is how the engine running a method calls the functions passed to it
+type applyFunc func(fn object.Object, args []object.Object) object.Object
+
+// method is one entry of a type's method table, fn is only called with arity arguments
+type method struct {
+	arity int
+	fn func(receiver object.Object, args []object.Object, apply applyFunc) object.Object
+}
+
+// methods are the methods of each type, integers share theirs whichever size they are
+var methods = map[object.ObjectType]map[string]method{
+	object.ARRAY_OBJ: {
+		"map": {1, arrayMap},
+		"filter": {1, arrayFilter},
+		"reduce": {2, arrayReduce},
+	},
+	object.STRING_OBJ: {
+		"upper": {0, stringUpper},
+		"split": {1, stringSplit},
+		"trim": {0, stringTrim},
+		"contains": {1, stringContains},
+		"replace": {2, stringReplace},
+	},
+	object.HASH_OBJ: {
+		"keys": {0, hashKeys},
+		"values": {0, hashValues},
+		"has": {1, hashHas},
+		"delete": {1, hashDelete},
+		"merge": {1, hashMerge},
+	},
+	object.INTEGER_OBJ: integerMethods,
+	object.BIGINT_OBJ: integerMethods,
+}
+
+var integerMethods = map[string]method{
+	"abs": {0, integerAbs},
+	"pow": {1, integerPow},
+}
+
+// callMethod runs a method on receiver, apply is how the calling engine invokes function arguments
+func callMethod(receiver object.Object, name string, args []object.Object, apply applyFunc) object.Object {
+	m, ok := methods[receiver.Type()][name]
+	if !ok {
+		return newError("unknown method %s for %s", name, receiver.Type())
+	}
+	if err := checkArity(name, m.arity, 0, false, len(args)); err != nil {
+		return err
+	}
+
+	return m.fn(receiver, args, apply)
+}
+
+// isCallable reports whether obj is a user function of either engine or a builtin
+func isCallable(obj object.Object) bool {
+	return obj.Type() == object.FUNCTION_OBJ || obj.Type() == object.CLOSURE_OBJ || obj.Type() == object.BUILTIN_OBJ
+}
+
+func arrayMap(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	fn := args[0]
+	if !isCallable(fn) {
+		return newError("argument to map must be a function")
+	}
+
+	result := []object.Object{}
+	for _, e := range receiver.(*object.Array).Elements {
+		val := apply(fn, []object.Object{e})
+		if isError(val) {
+			return val
+		}
+		result = append(result, val)
+	}
+
+	return &object.Array{Elements: result}
+}
+
+func arrayFilter(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	fn := args[0]
+	if !isCallable(fn) {
+		return newError("argument to filter must be a function")
+	}
+
+	result := []object.Object{}
+	for _, e := range receiver.(*object.Array).Elements {
+		condition := apply(fn, []object.Object{e})
+		if isError(condition) {
+			return condition
+		}
+
+		if isTruthy(condition) {
+			result = append(result, e)
+		}
+	}
+
+	return &object.Array{Elements: result}
+}
+
+func arrayReduce(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	fn := args[0]
+	if !isCallable(fn) {
+		return newError("first argument to reduce must be a function")
+	}
+
+	accum := args[1]
+	for _, e := range receiver.(*object.Array).Elements {
+		accum = apply(fn, []object.Object{accum, e})
+		if isError(accum) {
+			return accum
+		}
+	}
+
+	return accum
+}
+
+// textArgument is the string value of a STRING or CHAR argument to the string method name
+func textArgument(name string, arg object.Object) (string, *object.Error) {
+	switch arg := arg.(type) {
+	case *object.String:
+		return arg.Value, nil
+	case *object.Char:
+		return string(arg.Value), nil
+	default:
+		return "", newError("argument to %s must be STRING, got %s", name, arg.Type())
+	}
+}
+
+func stringUpper(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	return &object.String{Value: strings.ToUpper(receiver.(*object.String).Value)}
+}
+
+// stringSplit splits on every separator, an empty separator splits the string into its characters
+func stringSplit(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	sep, err := textArgument("split", args[0])
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(receiver.(*object.String).Value, sep)
+	elements := make([]object.Object, len(parts))
+	for i, part := range parts {
+		elements[i] = &object.String{Value: part}
+	}
+	return &object.Array{Elements: elements}
+}
+
+func stringTrim(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	return &object.String{Value: strings.TrimSpace(receiver.(*object.String).Value)}
+}
+
+func stringContains(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	sub, err := textArgument("contains", args[0])
+	if err != nil {
+		return err
+	}
+	return nativeBoolToBooleanObject(strings.Contains(receiver.(*object.String).Value, sub))
+}
+
+func stringReplace(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	old, err := textArgument("replace", args[0])
+	if err != nil {
+		return err
+	}
+	replacement, err := textArgument("replace", args[1])
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: strings.ReplaceAll(receiver.(*object.String).Value, old, replacement)}
+}
+
+// sortedPairs is the pairs of h ordered by key so keys and values come out the same way every time, integers
+// and floats in numeric order and anything else by how it prints
+func sortedPairs(h *object.Hash) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		switch {
+		case isInteger(a) && isInteger(b):
+			return toBig(a).Cmp(toBig(b)) < 0
+		case isNumber(a) && isNumber(b):
+			return toFloat(a) < toFloat(b)
+		case a.Type() != b.Type():
+			return a.Type() < b.Type()
+		default:
+			return a.Inspect() < b.Inspect()
+		}
+	})
+	return pairs
+}
+
+func hashKeys(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	pairs := sortedPairs(receiver.(*object.Hash))
+	keys := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	return &object.Array{Elements: keys}
+}
+
+func hashValues(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	pairs := sortedPairs(receiver.(*object.Hash))
+	values := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair.Value
+	}
+	return &object.Array{Elements: values}
+}
+
+func hashHas(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	key, ok := args[0].(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", args[0].Type())
+	}
+
+	_, ok = receiver.(*object.Hash).Pairs[key.HashKey()]
+	return nativeBoolToBooleanObject(ok)
+}
+
+// hashDelete removes key from the hash in place and returns the value it held, null when it was not there
+func hashDelete(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	key, ok := args[0].(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", args[0].Type())
+	}
+
+	pairs := receiver.(*object.Hash).Pairs
+	pair, ok := pairs[key.HashKey()]
+	if !ok {
+		return NULL
+	}
+	delete(pairs, key.HashKey())
+	return pair.Value
+}
+
+// hashMerge is a new hash with the pairs of both, where they share a key the argument's value wins
+func hashMerge(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	other, ok := args[0].(*object.Hash)
+	if !ok {
+		return newError("argument to merge must be HASH, got %s", args[0].Type())
+	}
+
+	pairs := make(map[object.HashKey]object.HashPair, len(receiver.(*object.Hash).Pairs)+len(other.Pairs))
+	for k, pair := range receiver.(*object.Hash).Pairs {
+		pairs[k] = pair
+	}
+	for k, pair := range other.Pairs {
+		pairs[k] = pair
+	}
+	return &object.Hash{Pairs: pairs}
+}
+
+func integerAbs(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	if n, ok := receiver.(*object.Integer); ok {
+		if n.Value >= 0 {
+			return n
+		}
+		if neg, ok := negInt(n.Value); ok {
+			return &object.Integer{Value: neg}
+		}
+	}
+	return normalizeInt(new(big.Int).Abs(toBig(receiver))) // big integers, and the smallest int64 which has no negation
+}
+
+// integerPow raises the receiver to a non-negative integer power, refusing results too large to hold
+func integerPow(receiver object.Object, args []object.Object, apply applyFunc) object.Object {
+	exp, ok := args[0].(*object.Integer)
+	if !ok {
+		return newError("argument to pow must be INTEGER, got %s", args[0].Type())
+	}
+	if exp.Value < 0 {
+		return newError("negative exponent: %d", exp.Value)
+	}
+
+	base := toBig(receiver)
+	if bits := int64(base.BitLen() - 1); bits > 0 && exp.Value > maxShift/bits {
+		return newError("exponent too large: %d", exp.Value)
+	}
+	return normalizeInt(new(big.Int).Exp(base, big.NewInt(exp.Value), nil))
+}
@@ -0,0 +1,108 @@
+package evaluator
+
+import (
+	"APE/ast"
+	"APE/object"
+	"sort"
+)
+
+// The functions in this file expose the evaluator's semantics to the bytecode VM so both engines agree on
+// what every operator, builtin and method does.
+
+func EvalInfix(operator string, left object.Object, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func EvalPrefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func EvalIndex(left object.Object, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// EvalSlice is left[low:high], NULL stands for a bound that was left out
+func EvalSlice(left, low, high object.Object) object.Object {
+	return evalSliceExpression(left, low, high)
+}
+
+// SetIndex stores val at left[index], updating the array or hash in place
+func SetIndex(left object.Object, index object.Object, val object.Object) object.Object {
+	return evalSetIndex(left, index, val)
+}
+
+// Interpolate joins parts into the string an interpolated string literal makes of them
+func Interpolate(parts []object.Object) *object.String {
+	return interpolate(parts)
+}
+
+// CheckElementTypes is the error for storing val at index in container, a variable or element declared as typ
+func CheckElementTypes(typ *ast.TypeAnnotation, container string, index, val object.Object) *object.Error {
+	return checkElementTypes(typ, container, index, val)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// CallMethod runs receiver.method(args...), apply is used to call any function passed as an argument
+func CallMethod(receiver object.Object, method string, args []object.Object, apply func(object.Object, []object.Object) object.Object) object.Object {
+	return callMethod(receiver, method, args, apply)
+}
+
+// CheckArity reports a call to the function name passing got arguments, when it needs at least required of them,
+// accepts optional more and any number after those when it has a rest parameter
+func CheckArity(name string, required, optional int, rest bool, got int) *object.Error {
+	return checkArity(name, required, optional, rest, got)
+}
+
+// NewThrownError is the error a `throw` of val raises
+func NewThrownError(val object.Object) *object.Error {
+	return &object.Error{Message: thrownMessage(val), Value: val}
+}
+
+// ErrorToHash is the value a catch block binds for err
+func ErrorToHash(err *object.Error) *object.Hash {
+	return errorToHash(err)
+}
+
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// the builtins in the fixed order the compiler refers to them by, worked out once rather than on every lookup
+var builtinNames, builtinList, builtinIndexes = sortBuiltins()
+
+func sortBuiltins() ([]string, []*object.Builtin, map[string]int) {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*object.Builtin, len(names))
+	indexes := make(map[string]int, len(names))
+	for i, name := range names {
+		list[i] = builtins[name]
+		indexes[name] = i
+	}
+	return names, list, indexes
+}
+
+// BuiltinNames lists every builtin in a fixed order so the compiler can refer to them by index, callers must not
+// modify it
+func BuiltinNames() []string {
+	return builtinNames
+}
+
+// BuiltinIndex is the index of the builtin called name in BuiltinNames
+func BuiltinIndex(name string) (int, bool) {
+	i, ok := builtinIndexes[name]
+	return i, ok
+}
+
+// BuiltinAt is the builtin at index i in BuiltinNames
+func BuiltinAt(i int) *object.Builtin {
+	return builtinList[i]
+}
@@ -1,55 +1,152 @@
 package lexer
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 	"APE/token"
 )
 
+// char is a decoded character and the bytes of the input it was decoded from
+type char struct {
+	ch  rune
+	raw string
+}
+
 type Lexer struct {
-	input        string
-	position     int
-	readPosition int
-	ch           byte
+	reader *bufio.Reader
+	ahead  []char // characters read from reader that peekChar has looked at but readChar has not reached
+	err    error  // the first error reading the input, other than io.EOF
+
+	ch  rune   // the current character, which may take several bytes of the input
+	raw string // the bytes of the current character as they were in the input
+
+	text    strings.Builder // what the lexer has read since mark was called
+	marking bool
+
+	filename string
+	line     int // line of the current character
+	column   int // column of the current character
+
+	templates []int // the braces left open in each interpolation being read, innermost last
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile creates a lexer whose token positions are reported against filename
+func NewFile(filename string, input string) *Lexer {
+	return NewReader(filename, strings.NewReader(input))
+}
+
+// NewReader creates a lexer that reads its input from r as it needs it, so the source never has to be held in
+// memory all at once. A failure reading r ends the input early and is reported by Err.
+func NewReader(filename string, r io.Reader) *Lexer {
+	l := &Lexer{reader: bufio.NewReader(r), filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
+// Err is the error that stopped the lexer reading its input, nil if it read all of it
+func (l *Lexer) Err() error {
+	return l.err
+}
 
-func newToken(tokenType token.TokenType, ch byte) token.Token { // initializes a token called from NextToken and returns the type and the associated character 
+func (l *Lexer) currentPos() token.Position {
+	return token.Position{Filename: l.filename, Line: l.line, Column: l.column}
+}
+
+
+func newToken(tokenType token.TokenType, ch rune) token.Token { // initializes a token called from NextToken and returns the type and the associated character 
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token { // consumes the current and next character as one token
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
 func (l *Lexer) readChar() { // check current character and increments the counter to check next character 
-	if l.readPosition >= len(l.input) { // check if its the last character in the input
-		l.ch = 0 // indicates EOF or end of input
+	if l.ch == '\n' { // the character we are moving past ended a line
+		l.line += 1
+		l.column = 0
+	}
+	l.column += 1
+
+	if l.marking {
+		l.text.WriteString(l.raw)
+	}
+
+	var next char
+	if len(l.ahead) > 0 {
+		next, l.ahead = l.ahead[0], l.ahead[1:]
 	} else {
-		l.ch = l.input[l.readPosition] // read char
+		next = l.decodeChar()
 	}
-	l.position = l.readPosition
-	l.readPosition += 1 // increment to next character
+	l.ch, l.raw = next.ch, next.raw // a ch of 0 indicates EOF or end of input
 }
 
-func (l *Lexer) peekChar() byte { // looks ahead by one to check the character ahead. 
-	if l.readPosition >= len(l.input) { // since readposition is +1 char of position we are looking ahead in order to  
-		return 0
-	} else {
-		return l.input[l.readPosition]
+// decodeChar reads the next character from the input, a byte that is not valid UTF-8 is utf8.RuneError on its own
+func (l *Lexer) decodeChar() char {
+	buf, err := l.reader.Peek(utf8.UTFMax)
+	if len(buf) == 0 {
+		if err != io.EOF && l.err == nil {
+			l.err = err
+		}
+		return char{}
 	}
+
+	r, width := rune(buf[0]), 1
+	if r >= utf8.RuneSelf {
+		r, width = utf8.DecodeRune(buf)
+	}
+	raw := string(buf[:width])
+	l.reader.Discard(width)
+
+	return char{ch: r, raw: raw}
+}
+
+func (l *Lexer) peekChar() rune { // looks ahead by one to check the character ahead. 
+	return l.peekCharAt(1)
+}
+
+func (l *Lexer) peekCharAt(offset int) rune { // looks ahead by offset characters, peekCharAt(1) is the same as peekChar
+	for len(l.ahead) < offset {
+		l.ahead = append(l.ahead, l.decodeChar())
+	}
+	return l.ahead[offset-1].ch
+}
+
+// mark starts recording the input at the current character, marked returns what was read since
+func (l *Lexer) mark() {
+	l.text.Reset()
+	l.marking = true
+}
+
+func (l *Lexer) marked() string {
+	l.marking = false
+	return l.text.String()
 }
 
 
 func (l *Lexer) readIdentifier() string { // finds the lexers position and then increments until white space returning where it started and finished 
-	position := l.position
+	l.mark()
 	for isLetter(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return l.marked()
 }
 
-func isLetter(ch byte) bool { // returns all character from a to Z, and also _ as a special case for functions identifing valid characters. 
+func isLetter(ch rune) bool { // returns all character from a to Z, and also _ as a special case for functions identifing valid characters. 
+	if ch >= utf8.RuneSelf {
+		return ch != utf8.RuneError && unicode.IsLetter(ch) // letters of any script work in identifiers
+	}
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
 
@@ -59,40 +156,390 @@ func (l *Lexer) consumeWhitespace() { // consumes a whitespace if it is found.
 	}
 }
 
-func (l *Lexer) readNumber() string { // checks the digit of the currently selected character by the lexer
-	position := l.position 
-	for isDigit(l.ch) {
+// bases are the integer prefixes after a leading 0, with the base and the name errors use for it
+var bases = map[rune]struct {
+	base int
+	name string
+}{
+	'x': {16, "hexadecimal"}, 'X': {16, "hexadecimal"},
+	'o': {8, "octal"}, 'O': {8, "octal"},
+	'b': {2, "binary"}, 'B': {2, "binary"},
+}
+
+// readNumber reads an integer or a float, a `.` only starts a fraction when a digit follows it so 5.abs() stays a method call.
+// Integers can be written in hex, octal or binary with a 0x, 0o or 0b prefix, and `_` can separate the digits of any number.
+// A malformed literal gives an ILLEGAL token at the character that is wrong. The literal keeps its spelling.
+func (l *Lexer) readNumber(pos token.Position) token.Token {
+	l.mark()
+	var tokenType token.TokenType = token.INT
+	var illegal *token.Token
+
+	fail := func(at token.Position, format string, a ...interface{}) {
+		if illegal == nil {
+			illegal = &token.Token{Type: token.ILLEGAL, Literal: fmt.Sprintf(format, a...), Pos: at}
+		}
+	}
+
+	if prefix, ok := bases[l.peekChar()]; l.ch == '0' && ok {
+		l.readChar()
+		l.readChar()
+
+		digits := 0
+		for isLetter(l.ch) || isDigit(l.ch) { // read the whole word so a bad digit is reported rather than starting a new token
+			switch {
+			case l.ch == '_':
+				if digitValue(l.peekChar()) >= prefix.base {
+					fail(l.currentPos(), "'_' must separate successive digits")
+				}
+			case digitValue(l.ch) < prefix.base:
+				digits++
+			default:
+				fail(l.currentPos(), "invalid digit %q in %s literal", l.ch, prefix.name)
+			}
+			l.readChar()
+		}
+
+		if digits == 0 {
+			fail(pos, "%s literal has no digits", prefix.name)
+		}
+		literal := l.marked()
+		if illegal != nil {
+			return *illegal
+		}
+		return token.Token{Type: token.INT, Literal: literal, Pos: pos}
+	}
+
+	l.readDigits(fail)
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits(fail)
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if isDigit(next) || ((next == '+' || next == '-') && isDigit(l.peekCharAt(2))) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits(fail)
+		}
+	}
+
+	literal := l.marked()
+	if tokenType == token.INT && len(literal) > 1 && literal[0] == '0' { // 010 would otherwise be read as octal
+		fail(pos, "decimal literal cannot start with 0, write 0o for an octal literal")
+	}
+	if illegal != nil {
+		return *illegal
+	}
+	return token.Token{Type: tokenType, Literal: literal, Pos: pos}
+}
+
+// readDigits reads decimal digits and the `_` separators between them, starting on a digit
+func (l *Lexer) readDigits(fail func(token.Position, string, ...interface{})) {
+	for isDigit(l.ch) || l.ch == '_' {
+		if l.ch == '_' && !isDigit(l.peekChar()) {
+			fail(l.currentPos(), "'_' must separate successive digits")
+		}
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
-func isDigit(ch byte) bool { 
+func isDigit(ch rune) bool { 
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) readString() string { 
-  position := l.position + 1 
+// digitValue is the value of ch as a digit in bases up to 16, and 16 for anything that is not a digit
+func digitValue(ch rune) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'F':
+		return int(ch-'A') + 10
+	default:
+		return 16
+	}
+}
+
+// readString reads a string literal and decodes its escapes, a bad escape or a missing closing quote gives an ILLEGAL token.
+// A ${ ends the text of a template string, the expression after it is lexed as normal tokens and the } closing it
+// goes on reading the string, so "a ${x} b" is TEMPLATE_HEAD("a ") IDENT(x) TEMPLATE_TAIL(" b").
+// head is false when reading resumes after an interpolation.
+func (l *Lexer) readString(pos token.Position, head bool) token.Token {
+	var out strings.Builder
+	var illegal *token.Token // the first bad escape, reported once the whole string has been read
 
 	for {
 		l.readChar()
-		if (l.ch == '"' || l.ch == 0) {
-			break
+
+		switch l.ch {
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated string", Pos: pos}
+		case '"':
+			if illegal != nil {
+				return *illegal
+			}
+			if head {
+				return token.Token{Type: token.STRING, Literal: out.String(), Pos: pos}
+			}
+			return token.Token{Type: token.TEMPLATE_TAIL, Literal: out.String(), Pos: pos}
+		case '$':
+			if l.peekChar() != '{' {
+				out.WriteByte('$')
+				continue
+			}
+			l.readChar()
+			l.templates = append(l.templates, 0)
+
+			if illegal != nil {
+				return *illegal
+			}
+			if head {
+				return token.Token{Type: token.TEMPLATE_HEAD, Literal: out.String(), Pos: pos}
+			}
+			return token.Token{Type: token.TEMPLATE_MIDDLE, Literal: out.String(), Pos: pos}
+		case '\\':
+			escPos := l.currentPos()
+			if msg := l.readEscape(&out); msg != "" && illegal == nil {
+				illegal = &token.Token{Type: token.ILLEGAL, Literal: msg, Pos: escPos}
+			}
+		default:
+			out.WriteString(l.raw) // as written, even if it is not valid UTF-8
+		}
+	}
+}
+
+// readRawString reads a backtick string, which can span lines and keeps every character as written. When the
+// opening backtick ends its line the string is a block: that newline is dropped and the indentation its lines
+// share is stripped, counting the line of the closing backtick, so the text can be indented with the code around it.
+func (l *Lexer) readRawString(pos token.Position) token.Token {
+	l.readChar()
+	l.mark()
+
+	for {
+		switch l.ch {
+		case 0:
+			l.marked()
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated raw string", Pos: pos}
+		case '`':
+			value := l.marked()
+			if strings.HasPrefix(value, "\n") {
+				value = dedent(value[1:])
+			}
+			return token.Token{Type: token.STRING, Literal: value, Pos: pos}
 		}
+
+		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
+// dedent removes the leading spaces and tabs every line of s has, lines holding nothing else are left out when
+// working out how much that is, apart from the last one which is where the closing backtick sits
+func dedent(s string) string {
+	lines := strings.Split(s, "\n")
 
-func (l *Lexer) NextToken() token.Token { // identifies and returns the next token in the input
-	var tok token.Token
+	indent := -1
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" && i != len(lines)-1 {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent == -1 || n < indent {
+			indent = n
+		}
+	}
+
+	for i, line := range lines {
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if n > indent {
+			n = indent
+		}
+		lines[i] = line[n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// readCharLiteral reads a single quoted character, escapes work the same as in strings
+func (l *Lexer) readCharLiteral(pos token.Position) token.Token {
+	var out strings.Builder
+	var illegal *token.Token
+
+	for {
+		l.readChar()
+
+		switch l.ch {
+		case 0, '\n':
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated character literal", Pos: pos}
+		case '\'':
+			if illegal != nil {
+				return *illegal
+			}
+
+			value := out.String()
+			r, size := utf8.DecodeRuneInString(value)
+			if value == "" || size != len(value) || r == utf8.RuneError {
+				return token.Token{Type: token.ILLEGAL, Literal: "character literal must hold exactly one character", Pos: pos}
+			}
+			return token.Token{Type: token.CHAR, Literal: value, Pos: pos}
+		case '\\':
+			escPos := l.currentPos()
+			if msg := l.readEscape(&out); msg != "" && illegal == nil {
+				illegal = &token.Token{Type: token.ILLEGAL, Literal: msg, Pos: escPos}
+			}
+		default:
+			out.WriteString(l.raw) // as written, even if it is not valid UTF-8
+		}
+	}
+}
+
+// readEscape decodes the escape starting at the current backslash into out, returning a message if it is not valid
+func (l *Lexer) readEscape(out *strings.Builder) string {
+	switch l.peekChar() {
+	case 0:
+		return "" // the string is unterminated, readString reports that
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '\\':
+		out.WriteByte('\\')
+	case '"':
+		out.WriteByte('"')
+	case '$':
+		out.WriteByte('$')
+	case '\'':
+		out.WriteByte('\'')
+	case 'u':
+		l.readChar()
+		return l.readUnicodeEscape(out)
+	default:
+		l.readChar()
+		return fmt.Sprintf("unknown escape sequence '\\%c'", l.ch)
+	}
+
+	l.readChar()
+	return ""
+}
+
+// readUnicodeEscape reads the {hex} of a \u{hex} escape, the current character is the u
+func (l *Lexer) readUnicodeEscape(out *strings.Builder) string {
+	if l.peekChar() != '{' {
+		return "invalid unicode escape, expected \\u{hex}"
+	}
+	l.readChar()
+
+	var hex strings.Builder
+	for isHexDigit(l.peekChar()) {
+		l.readChar()
+		hex.WriteRune(l.ch)
+	}
+	digits := hex.String()
+
+	if l.peekChar() != '}' || len(digits) == 0 || len(digits) > 6 {
+		return "invalid unicode escape, expected \\u{hex}"
+	}
+	l.readChar()
+
+	code, _ := strconv.ParseUint(digits, 16, 32)
+	if !utf8.ValidRune(rune(code)) {
+		return fmt.Sprintf("invalid unicode code point U+%s", strings.ToUpper(digits))
+	}
+
+	out.WriteRune(rune(code))
+	return ""
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+
+// NextToken identifies and returns the next token in the input, comments in front of it are kept on tok.Comments
+func (l *Lexer) NextToken() token.Token {
+	comments := []string{}
 
 	l.consumeWhitespace()
 
+	for l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*') {
+		pos := l.currentPos()
+
+		if l.peekChar() == '/' {
+			comments = append(comments, l.readLineComment())
+		} else {
+			comment, ok := l.readBlockComment()
+			if !ok {
+				return token.Token{Type: token.ILLEGAL, Literal: "unterminated block comment", Pos: pos}
+			}
+			comments = append(comments, comment)
+		}
+
+		l.consumeWhitespace()
+	}
+
+	tok := l.readToken()
+	if len(comments) > 0 {
+		tok.Comments = comments
+	}
+
+	return tok
+}
+
+func (l *Lexer) readLineComment() string { // reads from // up to, but not including, the end of the line
+	l.mark()
+
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+
+	return l.marked()
+}
+
+// readBlockComment reads a /* */ comment including its delimiters, comments may nest so every /* needs its own */
+func (l *Lexer) readBlockComment() (string, bool) {
+	l.mark()
+	depth := 0
+
+	for {
+		switch {
+		case l.ch == 0:
+			return l.marked(), false
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return l.marked(), true
+			}
+		}
+
+		l.readChar()
+	}
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
+	pos := l.currentPos()
+
 	switch l.ch {
 		case '"': 
-			tok.Type = token.STRING 
-			tok.Literal = l.readString()
+			tok = l.readString(pos, true)
+		case '`':
+			tok = l.readRawString(pos)
+		case '\'':
+			tok = l.readCharLiteral(pos)
 		case '=': 
 			if l.peekChar() == '=' {
 				ch := l.ch
@@ -109,9 +556,21 @@ func (l *Lexer) NextToken() token.Token { // identifies and returns the next tok
 		case ')': 
 			tok = newToken(token.RPAREN, l.ch)
 		case '{':
+			if n := len(l.templates); n > 0 {
+				l.templates[n-1]++
+			}
 			tok = newToken(token.LBRACE, l.ch)
 		case '}':
-			tok = newToken(token.RBRACE, l.ch)
+			n := len(l.templates)
+			if n > 0 && l.templates[n-1] == 0 { // closes an interpolation, the string goes on after it
+				l.templates = l.templates[:n-1]
+				tok = l.readString(pos, false)
+			} else {
+				if n > 0 {
+					l.templates[n-1]--
+				}
+				tok = newToken(token.RBRACE, l.ch)
+			}
 		case '[':
 			tok = newToken(token.LBRACKET, l.ch)
 		case ']': 
@@ -129,8 +588,20 @@ func (l *Lexer) NextToken() token.Token { // identifies and returns the next tok
 				literal := string(ch) + string(l.ch)
 				tok = token.Token{Type: token.OR, Literal: literal}
 			} else {
-				tok = newToken(token.EOF, l.ch)
+				tok = newToken(token.SINGLE_BAR, l.ch)
+			}
+		case '&':
+			if l.peekChar() == '&' {
+				tok = l.readTwoCharToken(token.AND)
+			} else {
+				tok = newToken(token.AMPERSAND, l.ch)
 			}
+		case '^':
+			tok = newToken(token.CARET, l.ch)
+		case '~':
+			tok = newToken(token.TILDE, l.ch)
+		case '%':
+			tok = newToken(token.PERCENT, l.ch)
 		case '-':
 			tok = newToken(token.MINUS, l.ch)
 		case '!':
@@ -147,11 +618,29 @@ func (l *Lexer) NextToken() token.Token { // identifies and returns the next tok
 		case '*':
 			tok = newToken(token.ASTERISK, l.ch)
 		case '<':
-			tok = newToken(token.LT, l.ch)
+			if l.peekChar() == '=' {
+				tok = l.readTwoCharToken(token.LT_EQ)
+			} else if l.peekChar() == '<' {
+				tok = l.readTwoCharToken(token.SHIFT_LEFT)
+			} else {
+				tok = newToken(token.LT, l.ch)
+			}
 		case '>':
-			tok = newToken(token.GT, l.ch) 
+			if l.peekChar() == '=' {
+				tok = l.readTwoCharToken(token.GT_EQ)
+			} else if l.peekChar() == '>' {
+				tok = l.readTwoCharToken(token.SHIFT_RIGHT)
+			} else {
+				tok = newToken(token.GT, l.ch) 
+			}
 		case '.':
-			tok = newToken(token.DOT, l.ch)
+			if l.peekChar() == '.' && l.peekCharAt(2) == '.' {
+				l.readChar()
+				l.readChar()
+				tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+			} else {
+				tok = newToken(token.DOT, l.ch)
+			}
 		case 0:
 			tok.Literal = ""
 			tok.Type = token.EOF
@@ -159,16 +648,21 @@ func (l *Lexer) NextToken() token.Token { // identifies and returns the next tok
 			if isLetter(l.ch) {
 				tok.Literal = l.readIdentifier()
 				tok.Type = token.LookupIdent(tok.Literal)
+				tok.Pos = pos
 				return tok 
 			} else if isDigit(l.ch) {
-				tok.Type = token.INT
-				tok.Literal = l.readNumber()
-				return tok
+				return l.readNumber(pos)
 			} else {
-				tok = newToken(token.ILLEGAL, l.ch)
+				tok = token.Token{Type: token.ILLEGAL, Literal: fmt.Sprintf("unexpected character %q", l.ch)}
+				if l.ch == utf8.RuneError && len(l.raw) == 1 {
+					tok.Literal = "invalid UTF-8 encoding"
+				}
 			}
 	}
 
 	l.readChar()
+	if !tok.Pos.IsValid() { // a bad escape is reported where it is rather than at the start of its string
+		tok.Pos = pos
+	}
 	return tok
 }
@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 
 	"APE/token"
@@ -16,7 +20,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -153,3 +157,551 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x + "ab"
+fn`
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"+", 2, 5},
+		{"ab", 2, 7},
+		{"fn", 3, 1},
+		{"", 3, 3},
+	}
+
+	l := NewFile("test.ape", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Errorf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d", i, tt.expectedLine, tt.expectedColumn, tok.Pos.Line, tok.Pos.Column)
+		}
+
+		if tok.Pos.Filename != "test.ape" {
+			t.Errorf("tests[%d] - filename wrong. got=%q", i, tok.Pos.Filename)
+		}
+	}
+}
+
+func TestNumberTokens(t *testing.T) {
+	input := `3.14 1e-9 2.5E+3 7e2 42 5.abs() 1.x 0xFF 0o17 0B1010 1_000_000 0x_dead_BEEF 3.141_592 1_0e1_0 0x1F.abs() 0 0.5 0e3`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.FLOAT, "7e2"},
+		{token.INT, "42"},
+		{token.INT, "5"},
+		{token.DOT, "."},
+		{token.IDENT, "abs"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.INT, "0xFF"},
+		{token.INT, "0o17"},
+		{token.INT, "0B1010"},
+		{token.INT, "1_000_000"},
+		{token.INT, "0x_dead_BEEF"},
+		{token.FLOAT, "3.141_592"},
+		{token.FLOAT, "1_0e1_0"},
+		{token.INT, "0x1F"},
+		{token.DOT, "."},
+		{token.IDENT, "abs"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.INT, "0"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "0e3"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNumberErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		line     int
+		column   int
+	}{
+		{"0x", "hexadecimal literal has no digits", 1, 1},
+		{"let n = 0b;", "binary literal has no digits", 1, 9},
+		{"0b1021", "invalid digit '2' in binary literal", 1, 5},
+		{"0o78", "invalid digit '8' in octal literal", 1, 4},
+		{"0xfg", "invalid digit 'g' in hexadecimal literal", 1, 4},
+		{"1__000", "'_' must separate successive digits", 1, 2},
+		{"1000_", "'_' must separate successive digits", 1, 5},
+		{"0xff_", "'_' must separate successive digits", 1, 5},
+		{"1_.5", "'_' must separate successive digits", 1, 2},
+		{"2.5_e3", "'_' must separate successive digits", 1, 4},
+		{"x = 1;\n  42_", "'_' must separate successive digits", 2, 5},
+		{"010", "decimal literal cannot start with 0, write 0o for an octal literal", 1, 1},
+		{"let n = 09;", "decimal literal cannot start with 0, write 0o for an octal literal", 1, 9},
+		{"0_1", "decimal literal cannot start with 0, write 0o for an octal literal", 1, 1},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		var tok token.Token
+		for tok = l.NextToken(); tok.Type != token.ILLEGAL && tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("%s - expected ILLEGAL token. got=%q", tt.input, tok.Type)
+			continue
+		}
+
+		if tok.Literal != tt.expected {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+
+		if tok.Pos.Line != tt.line || tok.Pos.Column != tt.column {
+			t.Errorf("%s - position wrong. expected=%d:%d, got=%d:%d", tt.input, tt.line, tt.column, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+
+	// the lexer carries on after the bad number
+	l := New("0b12 5")
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.INT || tok.Literal != "5" {
+		t.Errorf("expected INT 5 after the bad number. got=%q %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing comment
+/* block
+   comment */ x / 2;
+/* outer /* nested */ still outer */ x`
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.LET, "let", []string{"// leading comment"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "5", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// trailing comment", "/* block\n   comment */"}},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"/* outer /* nested */ still outer */"}},
+		{token.EOF, "", nil},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if len(tok.Comments) != len(tt.expectedComments) {
+			t.Fatalf("tests[%d] - wrong number of comments. expected=%q, got=%q", i, tt.expectedComments, tok.Comments)
+		}
+
+		for j, comment := range tt.expectedComments {
+			if tok.Comments[j] != comment {
+				t.Errorf("tests[%d] - comment wrong. expected=%q, got=%q", i, comment, tok.Comments[j])
+			}
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("let x = 1;\n/* never /* closed */")
+
+	var tok token.Token
+	for tok = l.NextToken(); tok.Type != token.ILLEGAL && tok.Type != token.EOF; tok = l.NextToken() {
+	}
+
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("expected ILLEGAL token. got=%q", tok.Type)
+	}
+
+	if tok.Literal != "unterminated block comment" {
+		t.Errorf("literal wrong. got=%q", tok.Literal)
+	}
+
+	if tok.Pos.Line != 2 || tok.Pos.Column != 1 {
+		t.Errorf("position wrong. got=%d:%d", tok.Pos.Line, tok.Pos.Column)
+	}
+}
+
+func TestOperatorTokens(t *testing.T) {
+	input := `<= >= < > && || & | ^ ~ << >> % ... .`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LT_EQ, "<="},
+		{token.GT_EQ, ">="},
+		{token.LT, "<"},
+		{token.GT, ">"},
+		{token.AND, "&&"},
+		{token.OR, "||"},
+		{token.AMPERSAND, "&"},
+		{token.SINGLE_BAR, "|"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.SHIFT_RIGHT, ">>"},
+		{token.PERCENT, "%"},
+		{token.ELLIPSIS, "..."},
+		{token.DOT, "."},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"tab\there"`, "tab\there"},
+		{`"back\\slash"`, `back\slash`},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"\r"`, "\r"},
+		{`"\u{41}\u{e9}\u{1F600}"`, "Aé😀"},
+		{`""`, ""},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != token.STRING {
+			t.Errorf("%s - tokentype wrong. expected=STRING, got=%q (%q)", tt.input, tok.Type, tok.Literal)
+			continue
+		}
+
+		if tok.Literal != tt.expected {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		line     int
+		column   int
+	}{
+		{`"abc`, "unterminated string", 1, 1},
+		{"let s = \"abc\ndef", "unterminated string", 1, 9},
+		{`"a\qb"`, `unknown escape sequence '\q'`, 1, 3},
+		{`"\u41"`, `invalid unicode escape, expected \u{hex}`, 1, 2},
+		{`"\u{}"`, `invalid unicode escape, expected \u{hex}`, 1, 2},
+		{`"\u{D800}"`, "invalid unicode code point U+D800", 1, 2},
+		{`"\u{110000}"`, "invalid unicode code point U+110000", 1, 2},
+		{"let s = `abc\ndef", "unterminated raw string", 1, 9},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		var tok token.Token
+		for tok = l.NextToken(); tok.Type != token.ILLEGAL && tok.Type != token.EOF; tok = l.NextToken() {
+		}
+
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("%s - expected ILLEGAL token. got=%q", tt.input, tok.Type)
+			continue
+		}
+
+		if tok.Literal != tt.expected {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+
+		if tok.Pos.Line != tt.line || tok.Pos.Column != tt.column {
+			t.Errorf("%s - position wrong. expected=%d:%d, got=%d:%d", tt.input, tt.line, tt.column, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+
+	// the lexer carries on after the bad string
+	l := New(`"\q" 5`)
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.INT {
+		t.Errorf("expected INT after the bad string. got=%q", tok.Type)
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"`a\\nb \"${x}\"`", `a\nb "${x}"`},
+		{"`one\n  two`", "one\n  two"},
+		{"``", ""},
+		{"`\n    SELECT *\n      FROM t\n    `", "SELECT *\n  FROM t\n"},
+		{"`\n\t\t{\n\n\t\t\t\"a\": 1\n\t\t}`", "{\n\n\t\"a\": 1\n}"},
+		{"`\n    x\n  `", "  x\n"},
+		{"`\n`", ""},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != token.STRING {
+			t.Errorf("%q - tokentype wrong. expected=STRING, got=%q (%q)", tt.input, tok.Type, tok.Literal)
+			continue
+		}
+
+		if tok.Literal != tt.expected {
+			t.Errorf("%q - literal wrong. expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+	}
+
+	// lines inside the string still count towards the positions of the tokens after it
+	l := New("let q = `\n  a\n  b\n`;\nq")
+	for _, want := range []token.Token{
+		{Type: token.LET, Pos: token.Position{Line: 1, Column: 1}},
+		{Type: token.IDENT, Pos: token.Position{Line: 1, Column: 5}},
+		{Type: token.ASSIGN, Pos: token.Position{Line: 1, Column: 7}},
+		{Type: token.STRING, Pos: token.Position{Line: 1, Column: 9}},
+		{Type: token.SEMICOLON, Pos: token.Position{Line: 4, Column: 2}},
+		{Type: token.IDENT, Pos: token.Position{Line: 5, Column: 1}},
+	} {
+		tok := l.NextToken()
+		if tok.Type != want.Type || tok.Pos != want.Pos {
+			t.Errorf("wrong token. expected=%q at %s, got=%q at %s", want.Type, want.Pos, tok.Type, tok.Pos)
+		}
+	}
+}
+
+func TestTemplateStrings(t *testing.T) {
+	input := `"a ${x + {"k": 1}["k"]} b ${"${y}"}\${z} $c"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.TEMPLATE_HEAD, "a "},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.TEMPLATE_MIDDLE, " b "},
+		{token.TEMPLATE_HEAD, ""},
+		{token.IDENT, "y"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.TEMPLATE_TAIL, "${z} $c"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestUnicodeInput(t *testing.T) {
+	input := "let größe = \"日本\xff\"; größe\n\xff 名前"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		line, column    int
+	}{
+		{token.LET, "let", 1, 1},
+		{token.IDENT, "größe", 1, 5},
+		{token.ASSIGN, "=", 1, 11},
+		{token.STRING, "日本\xff", 1, 13},
+		{token.SEMICOLON, ";", 1, 18},
+		{token.IDENT, "größe", 1, 20},
+		{token.ILLEGAL, "invalid UTF-8 encoding", 2, 1},
+		{token.IDENT, "名前", 2, 3},
+		{token.EOF, "", 2, 5},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q %q, got=%q %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != tt.line || tok.Pos.Column != tt.column {
+			t.Errorf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d", i, tt.line, tt.column, tok.Pos.Line, tok.Pos.Column)
+		}
+	}
+}
+
+func TestCharLiterals(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedType token.TokenType
+		expected     string
+	}{
+		{`'a'`, token.CHAR, "a"},
+		{`'\n'`, token.CHAR, "\n"},
+		{`'\''`, token.CHAR, "'"},
+		{`'"'`, token.CHAR, `"`},
+		{`'\u{e9}'`, token.CHAR, "é"},
+		{`'é'`, token.CHAR, "é"},
+		{`''`, token.ILLEGAL, "character literal must hold exactly one character"},
+		{`'ab'`, token.ILLEGAL, "character literal must hold exactly one character"},
+		{`'a`, token.ILLEGAL, "unterminated character literal"},
+		{`'\q'`, token.ILLEGAL, `unknown escape sequence '\q'`},
+	}
+
+	for _, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("%s - tokentype wrong. expected=%q, got=%q (%q)", tt.input, tt.expectedType, tok.Type, tok.Literal)
+			continue
+		}
+
+		if tok.Literal != tt.expected {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	input := "let größe = 0x_ff + 1.5e3; // note\n/* a /* nested */ one */ \"${a[1:]} \\u{e9}\" `\n  raw\n  ` ...x 'é' \xff"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedLiteral string
+		line int
+		column int
+		comments string
+	}{
+		{token.LET, "let", 1, 1, ""},
+		{token.IDENT, "größe", 1, 5, ""},
+		{token.ASSIGN, "=", 1, 11, ""},
+		{token.INT, "0x_ff", 1, 13, ""},
+		{token.PLUS, "+", 1, 19, ""},
+		{token.FLOAT, "1.5e3", 1, 21, ""},
+		{token.SEMICOLON, ";", 1, 26, ""},
+		{token.TEMPLATE_HEAD, "", 2, 26, "// note|/* a /* nested */ one */"},
+		{token.IDENT, "a", 2, 29, ""},
+		{token.LBRACKET, "[", 2, 30, ""},
+		{token.INT, "1", 2, 31, ""},
+		{token.COLON, ":", 2, 32, ""},
+		{token.RBRACKET, "]", 2, 33, ""},
+		{token.TEMPLATE_TAIL, " é", 2, 34, ""},
+		{token.STRING, "raw\n", 2, 44, ""},
+		{token.ELLIPSIS, "...", 4, 5, ""},
+		{token.IDENT, "x", 4, 8, ""},
+		{token.CHAR, "é", 4, 10, ""},
+		{token.ILLEGAL, "invalid UTF-8 encoding", 4, 14, ""},
+		{token.EOF, "", 4, 15, ""},
+	}
+
+	// a reader handing over one byte at a time splits every multi-byte character across reads
+	l := NewReader("big.ape", iotest.OneByteReader(strings.NewReader(input)))
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - token wrong. expected=%q %q, got=%q %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+
+		want := token.Position{Filename: "big.ape", Line: tt.line, Column: tt.column}
+		if tok.Pos != want {
+			t.Errorf("tests[%d] - position wrong. expected=%s, got=%s", i, want, tok.Pos)
+		}
+
+		if comments := strings.Join(tok.Comments, "|"); comments != tt.comments {
+			t.Errorf("tests[%d] - comments wrong. expected=%q, got=%q", i, tt.comments, comments)
+		}
+	}
+
+	if l.Err() != nil {
+		t.Errorf("unexpected error: %v", l.Err())
+	}
+}
+
+func TestNewReaderError(t *testing.T) {
+	failure := errors.New("disk gone")
+	l := NewReader("big.ape", io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(failure)))
+
+	for _, want := range []token.TokenType{token.LET, token.IDENT, token.EOF} {
+		if tok := l.NextToken(); tok.Type != want {
+			t.Fatalf("wrong token. want=%q, got=%q", want, tok.Type)
+		}
+	}
+
+	if l.Err() != failure {
+		t.Errorf("Err() wrong. want=%v, got=%v", failure, l.Err())
+	}
+}
@@ -1,6 +1,7 @@
 package main 
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"strings"
@@ -11,6 +12,10 @@ import (
 	"APE/parser"
 	"APE/evaluator"
 	"APE/object"
+	"APE/compiler"
+	"APE/resolver"
+	"APE/typecheck"
+	"APE/vm"
 )
 
 func main() {
@@ -19,12 +24,25 @@ func main() {
 		panic(err)
 	}
 	
-	args := os.Args[1:]
+	engine := flag.String("engine", repl.ENGINE_EVAL, "engine to run programs with: eval or vm")
+	flag.Parse()
+
+	if *engine != repl.ENGINE_EVAL && *engine != repl.ENGINE_VM {
+		fmt.Printf("Error: unknown engine %q, expected eval or vm\n", *engine)
+		return
+	}
+
+	args := flag.Args()
+
+	if len(args) > 0 && args[0] == "tokens" {
+		tokensCommand(args[1:])
+		return
+	}
 
 	if len(args) == 0 {
 		fmt.Printf("Hello %s! Welcome to the APE programming language! \n", user.Username)
 		fmt.Printf("Type out commands\n")
-		repl.Start(os.Stdin, os.Stdout)
+		repl.Start(os.Stdin, os.Stdout, *engine)
 	} else {
 		filename := args[0]
 
@@ -40,22 +58,53 @@ func main() {
 			return 
 		}
 
-		executeAPE(string(content))
+		executeAPE(filename, string(content), *engine)
 	}
 }
 
-func executeAPE(input string) {
+func executeAPE(filename string, input string, engine string) {
 	env := object.NewEnvironment()
-	l := lexer.New(input)
+	l := lexer.NewFile(filename, input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
 	if len(p.Errors()) != 0 {
-		fmt.Print("error")
+		for _, d := range p.Diagnostics() {
+			fmt.Println(d)
+		}
+		return
+	}
+
+	if typeErrors := typecheck.Check(program); len(typeErrors) != 0 {
+		for _, e := range typeErrors {
+			fmt.Println(e)
+		}
+		return
+	}
+
+	if resolveErrors := resolver.New(evaluator.BuiltinNames()).Resolve(program); len(resolveErrors) != 0 {
+		for _, e := range resolveErrors {
+			fmt.Println(e)
+		}
 		return
 	}
 	
-	evaluated := evaluator.Eval(program, env)
+	var evaluated object.Object
+	if engine == repl.ENGINE_VM {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			fmt.Println(err)
+			return
+		}
+		evaluated = vm.New(comp.Bytecode()).Run()
+	} else {
+		evaluated = evaluator.EvalSafely(program, env)
+	}
+
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Println(errObj.Traceback())
+		return
+	}
 
 	if evaluated != nil && evaluated.Type() != object.NULL_OBJ {
 		fmt.Println(evaluated.Inspect())
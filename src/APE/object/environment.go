@@ -1,9 +1,15 @@
 package object
 
+import "APE/ast"
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-    env := NewEnvironment()
-    env.outer = outer
-    return env 
+    return &Environment{outer: outer, depth: outer.depth}
+}
+
+// NewSizedEnvironment is an enclosed environment with room for size resolved slots, the way a function call starts.
+// outer is where the function was defined, depth is how many calls deep the call is wherever that was.
+func NewSizedEnvironment(outer *Environment, size int, depth int) *Environment {
+    return &Environment{slots: make([]Object, size), outer: outer, depth: depth}
 }
 
 func NewEnvironment() *Environment {
@@ -13,7 +19,16 @@ func NewEnvironment() *Environment {
 
 type Environment struct { 
     store map[string]Object
+    slots []Object // variables the resolver gave a slot, looked up by index instead of by name
     outer *Environment
+    types map[string]*ast.TypeAnnotation // declared types of the typed bindings in this scope
+    slotTypes []*ast.TypeAnnotation
+    depth int // how many function calls deep the code using this environment runs, 0 for the program itself
+}
+
+// Depth is how many function calls deep the code using e runs, so each evaluation keeps its own count
+func (e *Environment) Depth() int {
+    return e.depth
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -26,6 +41,108 @@ func (e *Environment) Get(name string) (Object, bool) {
 }
 
 func (e *Environment) Set(name string, val Object) Object {
+    if e.store == nil {
+        e.store = make(map[string]Object)
+    }
     e.store[name] = val
+    delete(e.types, name) // a plain let replaces a typed binding of the same name
+    return val
+}
+
+// SetTyped binds name to val and records the type later assignments to it have to keep
+func (e *Environment) SetTyped(name string, val Object, typ *ast.TypeAnnotation) Object {
+    if e.store == nil {
+        e.store = make(map[string]Object)
+    }
+    e.store[name] = val
+    if e.types == nil {
+        e.types = make(map[string]*ast.TypeAnnotation)
+    }
+    e.types[name] = typ
     return val
-}
\ No newline at end of file
+}
+
+// TypeOf returns the declared type of the binding name resolves to, nil if it was declared without one
+func (e *Environment) TypeOf(name string) *ast.TypeAnnotation {
+    for scope := e; scope != nil; scope = scope.outer {
+        if _, ok := scope.store[name]; ok {
+            return scope.types[name]
+        }
+    }
+
+    return nil
+}
+
+// Assign updates the binding in the scope that defines name, it reports false when no scope defines it
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+    for scope := e; scope != nil; scope = scope.outer {
+        if _, ok := scope.store[name]; ok {
+            scope.store[name] = val
+            return val, true
+        }
+    }
+
+    return nil, false
+}
+
+// scopeAt walks depth scopes out
+func (e *Environment) scopeAt(depth int) *Environment {
+    scope := e
+    for i := 0; i < depth && scope != nil; i++ {
+        scope = scope.outer
+    }
+    return scope
+}
+
+// GetAt returns the variable in slot of the scope depth levels out, false while it has not been bound yet
+func (e *Environment) GetAt(depth, slot int) (Object, bool) {
+    scope := e.scopeAt(depth)
+    if scope == nil || slot >= len(scope.slots) || scope.slots[slot] == nil {
+        return nil, false
+    }
+
+    return scope.slots[slot], true
+}
+
+// SetAt binds slot in this scope, growing it when the slot is new
+func (e *Environment) SetAt(slot int, val Object) Object {
+    for slot >= len(e.slots) {
+        e.slots = append(e.slots, nil)
+    }
+    e.slots[slot] = val
+    if slot < len(e.slotTypes) {
+        e.slotTypes[slot] = nil
+    }
+    return val
+}
+
+// SetTypedAt binds slot like SetAt and records its declared type
+func (e *Environment) SetTypedAt(slot int, val Object, typ *ast.TypeAnnotation) Object {
+    e.SetAt(slot, val)
+    for slot >= len(e.slotTypes) {
+        e.slotTypes = append(e.slotTypes, nil)
+    }
+    e.slotTypes[slot] = typ
+    return val
+}
+
+// TypeAt returns the declared type of slot in the scope depth levels out, nil if it has none
+func (e *Environment) TypeAt(depth, slot int) *ast.TypeAnnotation {
+    scope := e.scopeAt(depth)
+    if scope == nil || slot >= len(scope.slotTypes) {
+        return nil
+    }
+
+    return scope.slotTypes[slot]
+}
+
+// AssignAt updates slot of the scope depth levels out, it reports false when the slot was never bound
+func (e *Environment) AssignAt(depth, slot int, val Object) (Object, bool) {
+    scope := e.scopeAt(depth)
+    if scope == nil || slot >= len(scope.slots) || scope.slots[slot] == nil {
+        return nil, false
+    }
+
+    scope.slots[slot] = val
+    return val, true
+}
@@ -4,23 +4,34 @@ import (
 	"bytes"
 	"fmt"
 	"APE/ast"
+	"APE/code"
+	"APE/token"
 	"strings"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"strconv"
 )
 
 const (
 	INTEGER_OBJ = "INTEGER"
+	BIGINT_OBJ = "BIGINT"
+	FLOAT_OBJ = "FLOAT"
 	BUILTIN_OBJ = "BUILTIN"
 	BOOLEAN_OBJ = "BOOLEAN"
 	NULL_OBJ = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	STRING_OBJ = "STRING"
+	CHAR_OBJ = "CHAR"
 	HASH_OBJ = "HASH"
 	ERROR_OBJ = "ERROR"
 	FUNCTION_OBJ = "FUNCTION"
 	ARRAY_OBJ = "ARRAY"
 	BREAK_OBJ = "BREAK"
 	CONTINUE_OBJ = "CONTINUE"
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ = "CLOSURE"
+	TYPE_OBJ = "TYPE"
 )
 
 type ObjectType string
@@ -97,6 +108,54 @@ func (i *Integer) 	Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) 	Inspect() string { return fmt.Sprintf("%d", i.Value) }
 
 
+// BigInt is an integer too large for an Integer, arithmetic turns it back into an Integer once the value fits again
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return BIGINT_OBJ }
+
+func (b *BigInt) Inspect() string { return b.Value.String() }
+
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(b.Value.Bytes())
+
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+
+type Char struct {
+	Value rune
+}
+
+func (c *Char) Type() ObjectType { return CHAR_OBJ }
+
+func (c *Char) Inspect() string { return string(c.Value) }
+
+func (c *Char) HashKey() HashKey {
+	return HashKey{Type: c.Type(), Value: uint64(c.Value)}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows a decimal point or exponent so floats can be told apart from integers
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+
 type Boolean struct {
 	Value bool 
 }
@@ -124,17 +183,71 @@ func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 
 type Error struct {
 	Message string
+	Pos token.Position // where the error was raised, zero if unknown
+	Stack []StackFrame
+	Value Object // the value passed to throw, nil for errors raised by the interpreter
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
-func (e *Error) Inspect() string { return "ERROR: " + e.Message}
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// Traceback is the error message followed by one line per function call it unwound through, innermost first.
+// A run of identical frames, such as deep recursion leaves, is printed once with how many more times it repeats.
+func (e *Error) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Inspect())
+
+	for i := 0; i < len(e.Stack); {
+		frame := e.Stack[i]
+		out.WriteString("\n    ")
+		out.WriteString(frame.String())
+
+		run := 1
+		for i+run < len(e.Stack) && e.Stack[i+run] == frame {
+			run++
+		}
+		if run == 2 {
+			out.WriteString("\n    ... repeated 1 more time")
+		} else if run > 2 {
+			fmt.Fprintf(&out, "\n    ... repeated %d more times", run-1)
+		}
+		i += run
+	}
+
+	return out.String()
+}
+
+// StackFrame is a user function call that an error passed through, Pos is the call site
+type StackFrame struct {
+	Function string
+	Pos token.Position
+}
+
+func (sf StackFrame) String() string {
+	name := sf.Function
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	return fmt.Sprintf("at %s (%s)", name, sf.Pos)
+}
 
 
 type Function struct {
+	Name string // the name of the first let binding, empty for anonymous functions
 	Parameters []*ast.Identifier
+	Defaults []ast.Expression // default values of the parameters, see ast.FunctionLiteral
+	Rest *ast.Identifier
 	Body *ast.BlockStatement
 	Env *Environment
+	Locals int // slots a call's environment needs when the body was resolved
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -144,8 +257,15 @@ func (f *Function) Inspect() string {
 
 	params := []string{}
 
-	for _, p := range f.Parameters { 
-		params = append(params, p.String())
+	for i, p := range f.Parameters { 
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			params = append(params, p.String()+" = "+f.Defaults[i].String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
 	}
 
 	out.WriteString("fn")
@@ -159,6 +279,49 @@ func (f *Function) Inspect() string {
 }
 
 
+// CompiledFunction is a function literal lowered to bytecode by the compiler
+type CompiledFunction struct {
+	Instructions code.Instructions
+	SourceMap *code.SourceMap
+	NumLocals int
+	NumParameters int // the named parameters, the rest parameter comes after them
+	NumDefaults int // how many of the last parameters have a default value
+	Rest bool
+	Name string
+	Names map[int]string // the variable read by the local or free load at each offset, to report one read before it is set
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+
+func (cf *CompiledFunction) Inspect() string { return fmt.Sprintf("CompiledFunction[%p]", cf) }
+
+
+// Closure is a compiled function together with the locals of the functions it was created in, innermost first,
+// captured variables are shared with those functions rather than copied
+type Closure struct {
+	Fn *CompiledFunction
+	Outers [][]Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+
+func (c *Closure) Inspect() string {
+	if c.Fn.Name != "" {
+		return fmt.Sprintf("fn %s", c.Fn.Name)
+	}
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Type is a declared type kept as a constant so the VM can check typed bindings at runtime
+type Type struct {
+	Annotation *ast.TypeAnnotation
+}
+
+func (t *Type) Type() ObjectType { return TYPE_OBJ }
+
+func (t *Type) Inspect() string { return t.Annotation.String() }
+
+
 type HashKey struct { 
 	Type ObjectType
 	Value uint64
@@ -181,6 +344,19 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey gives a whole float the key of the integer it equals, so 1.0 finds a value stored under 1
+func (f *Float) HashKey() HashKey {
+	value := f.Value
+	if value == math.Trunc(value) && !math.IsInf(value, 0) {
+		if value >= math.MinInt64 && value < math.MaxInt64 {
+			return (&Integer{Value: int64(value)}).HashKey()
+		}
+		whole, _ := new(big.Float).SetFloat64(value).Int(nil)
+		return (&BigInt{Value: whole}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(value)}
+}
+
 func (s *String) HashKey() HashKey { 
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"APE/token"
+	"math/big"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -21,3 +25,106 @@ func TestStringHashKey(t *testing.T) {
 	}
 }
 
+func TestBigIntHashKey(t *testing.T) {
+	parse := func(s string) *BigInt {
+		v, _ := new(big.Int).SetString(s, 10)
+		return &BigInt{Value: v}
+	}
+
+	if parse("99999999999999999999").HashKey() != parse("99999999999999999999").HashKey() {
+		t.Errorf("big integers with the same value have different hash keys")
+	}
+
+	if parse("99999999999999999999").HashKey() == parse("-99999999999999999999").HashKey() {
+		t.Errorf("a big integer and its negation have the same hash key")
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	huge, _ := new(big.Int).SetString("100000000000000000000", 10)
+
+	tests := []struct {
+		float float64
+		key Hashable
+	}{
+		{1.0, &Integer{Value: 1}},
+		{-3.0, &Integer{Value: -3}},
+		{-0.0, &Integer{Value: 0}},
+		{1e20, &BigInt{Value: huge}},
+	}
+
+	for _, tt := range tests {
+		if (&Float{Value: tt.float}).HashKey() != tt.key.HashKey() {
+			t.Errorf("%g does not share the hash key of the integer it equals", tt.float)
+		}
+	}
+
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("different floats have the same hash key")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value float64
+		expected string
+	}{
+		{3.0, "3.0"},
+		{3.25, "3.25"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. expected=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("count", &Integer{Value: 1})
+
+	inner := NewEnclosedEnvironment(NewEnclosedEnvironment(global))
+
+	if _, ok := inner.Assign("count", &Integer{Value: 2}); !ok {
+		t.Fatalf("assigning a variable from an outer scope failed")
+	}
+
+	if _, ok := inner.store["count"]; ok {
+		t.Errorf("assignment created a binding in the inner scope")
+	}
+
+	val, _ := global.Get("count")
+	if val.(*Integer).Value != 2 {
+		t.Errorf("outer binding not updated. got=%s", val.Inspect())
+	}
+
+	if _, ok := inner.Assign("missing", &Integer{Value: 3}); ok {
+		t.Errorf("assigning an undeclared variable succeeded")
+	}
+
+	if _, ok := global.Get("missing"); ok {
+		t.Errorf("assigning an undeclared variable declared it")
+	}
+}
+
+func TestErrorTracebackCollapsesRepeatedFrames(t *testing.T) {
+	f := StackFrame{Function: "f", Pos: token.Position{Line: 1, Column: 20}}
+	g := StackFrame{Function: "g", Pos: token.Position{Line: 2, Column: 1}}
+
+	err := &Error{Message: "stack overflow", Stack: []StackFrame{f, f, f, f, g, g, f}}
+
+	expected := "ERROR: stack overflow" +
+		"\n    at f (1:20)" +
+		"\n    ... repeated 3 more times" +
+		"\n    at g (2:1)" +
+		"\n    ... repeated 1 more time" +
+		"\n    at f (1:20)"
+
+	if err.Traceback() != expected {
+		t.Errorf("wrong traceback. expected=\n%s\ngot=\n%s", expected, err.Traceback())
+	}
+}
@@ -0,0 +1,18 @@
+package parser
+
+import (
+	"fmt"
+	"APE/token"
+)
+
+// Diagnostic is a single error found while parsing, Expected is only set when a specific token was missing
+type Diagnostic struct {
+	Pos token.Position
+	Message string
+	Expected token.TokenType
+	Got token.Token
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: error: %s", d.Pos, d.Message)
+}
@@ -1,17 +1,22 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"APE/ast"
 	"APE/lexer"
 	"APE/token"
+	"math/big"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 
 type Parser struct {
 	l *lexer.Lexer
-	errors []string
+	diagnostics []Diagnostic
+	panicking bool // set after an error until the parser has synchronised, so one mistake is only reported once
 
 	curToken  token.Token
 	peekToken token.Token
@@ -21,15 +26,25 @@ type Parser struct {
 }
 
 var precedences = map[token.TokenType]int {
+	token.OR: LOGICAL_OR,
+	token.AND: LOGICAL_AND,
 	token.EQ: EQUALS,
 	token.NOT_EQ: EQUALS,
 	token.LT: LESSGREATER,
 	//token.ASSIGN: EQUALS,
 	token.GT: LESSGREATER,
+	token.LT_EQ: LESSGREATER,
+	token.GT_EQ: LESSGREATER,
+	token.SINGLE_BAR: BIT_OR,
+	token.CARET: BIT_XOR,
+	token.AMPERSAND: BIT_AND,
+	token.SHIFT_LEFT: SHIFT,
+	token.SHIFT_RIGHT: SHIFT,
 	token.PLUS: SUM, 
 	token.MINUS: SUM, 
 	token.SLASH: PRODUCT,
 	token.ASTERISK: PRODUCT, 
+	token.PERCENT: PRODUCT,
 	token.LPAREN: CALL, 
 	token.LBRACKET: INDEX, 
 	token.DOT: CALL,
@@ -41,8 +56,14 @@ const (
 	_ int = iota
 	LOWEST
 	ASSIGN // = 
+	LOGICAL_OR // ||
+	LOGICAL_AND // &&
 	EQUALS // == 
 	LESSGREATER // > or < 
+	BIT_OR // |
+	BIT_XOR // ^
+	BIT_AND // &
+	SHIFT // << or >>
 	SUM // +
 	PRODUCT // *
 	PREFIX // ++a or --b 
@@ -64,16 +85,70 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) { //
 }
 
 
+// report records a diagnostic unless the parser is already recovering from an earlier error
+func (p *Parser) report(d Diagnostic) {
+	if p.panicking {
+		return
+	}
+
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+func (p *Parser) errorAt(pos token.Position, format string, a ...interface{}) {
+	p.report(Diagnostic{Pos: pos, Message: fmt.Sprintf(format, a...), Got: p.curToken})
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.curToken.Pos, "no prefix parse function for %s found", t)
+}
+
+// synchronize skips tokens until the end of the broken statement: a `;`, the end of a nested block,
+// or the token just before the `}` closing the enclosing block
+func (p *Parser) synchronize() {
+	depth := 0
+
+	for !p.curTokenIs(token.EOF) {
+		switch {
+		case p.curTokenIs(token.LBRACE):
+			depth++
+		case p.curTokenIs(token.RBRACE) && depth > 0:
+			depth--
+			if depth == 0 { // a nested block closing ends the broken statement too, along with a `;` right after it
+				if p.peekTokenIs(token.SEMICOLON) {
+					p.nextToken()
+				}
+				p.panicking = false
+				return
+			}
+		case p.curTokenIs(token.SEMICOLON) && depth == 0:
+			p.panicking = false
+			return
+		}
+
+		if depth == 0 && p.peekTokenIs(token.RBRACE) {
+			break
+		}
+
+		p.nextToken()
+	}
+
+	p.panicking = false
 }
 
 func (p *Parser) parseAssignmentExpression(left ast.Expression) ast.Expression {
+	if target, ok := left.(*ast.IndexExpression); ok {
+		expression := &ast.IndexAssignmentExpression{Token: p.curToken, Target: target}
+
+		p.nextToken()
+		expression.Value = p.parseExpression(LOWEST)
+
+		return expression
+	}
+
 	identifier, ok := left.(*ast.Identifier)
 	if !ok {
-		msg := fmt.Sprintf("expected identifier on left side of assignment, got %T", left)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken.Pos, "expected identifier or index expression on left side of assignment, got %T", left)
 		return nil 
 	}
 
@@ -118,9 +193,14 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) { // too large for an int64, the literal becomes a big integer
+		if n, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			lit.Big = n
+			return lit
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as an integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken.Pos, "could not parse %q as an integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -130,24 +210,48 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 }
 
+func (p *Parser) parseCharLiteral() ast.Expression {
+	r, _ := utf8.DecodeRuneInString(p.curToken.Literal) // the lexer only produces CHAR tokens holding one character
+	return &ast.CharLiteral{Token: p.curToken, Value: r}
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(strings.ReplaceAll(p.curToken.Literal, "_", ""), 64)
+	if err != nil {
+		p.errorAt(p.curToken.Pos, "could not parse %q as a float", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l: l,
-		errors: []string{},
+		diagnostics: []Diagnostic{},
 	}
 	
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.WHILE, p.parseWhileExpression)
 	p.registerPrefix(token.FOR, p.parseForExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerInfix(token.ASSIGN, p.parseAssignmentExpression)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
+	p.registerPrefix(token.CHAR, p.parseCharLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
@@ -155,6 +259,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE_HEAD, p.parseInterpolatedString)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
@@ -165,6 +270,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.SINGLE_BAR, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
 	p.registerInfix(token.DOT,  p.parseCallMethodExpression)
 
 
@@ -260,6 +375,57 @@ func (p *Parser) parseForExpression() ast.Expression {
     return forExpr
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.errorAt(expression.Token.Pos, "try needs a catch or finally block")
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parseWhileExpression() ast.Expression {
 	expression := &ast.WhileExpression{Token: p.curToken}
 
@@ -335,11 +501,36 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 }
 
 
+// parseIndexExpression parses left[index], or the slice left[low:high] when a `:` follows the index or opens the brackets
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(tok, left, index)
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+}
+
+// parseSliceExpression parses what follows the `:` of a slice
+func (p *Parser) parseSliceExpression(tok token.Token, left, low ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tok, Left: left, Low: low}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
@@ -386,6 +577,39 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal }
 }
 
+// parseInterpolatedString parses the expressions between the text tokens of a template string
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken, Strings: []string{p.curToken.Literal}}
+
+	for {
+		if p.peekTokenIs(token.TEMPLATE_MIDDLE) || p.peekTokenIs(token.TEMPLATE_TAIL) {
+			p.errorAt(p.peekToken.Pos, "empty interpolation, expected an expression between ${ and }")
+			return nil
+		}
+
+		p.nextToken()
+		exp := p.parseExpression(LOWEST)
+		if exp == nil {
+			return nil
+		}
+		str.Expressions = append(str.Expressions, exp)
+
+		p.nextToken()
+		switch p.curToken.Type {
+		case token.TEMPLATE_MIDDLE:
+			str.Strings = append(str.Strings, p.curToken.Literal)
+		case token.TEMPLATE_TAIL:
+			str.Strings = append(str.Strings, p.curToken.Literal)
+			return str
+		case token.ILLEGAL:
+			return p.parseIllegal()
+		default:
+			p.errorAt(p.curToken.Pos, "expected } to close the interpolation, got %s instead", p.curToken.Type)
+			return nil
+		}
+	}
+}
+
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
@@ -428,7 +652,9 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) { 
 		return nil
@@ -440,31 +666,59 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 }
 
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+// parseFunctionParameters reads the parameters into lit, each one a name with an optional `= default`,
+// and the last one may be a `...rest` parameter
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
 
 	if p.peekTokenIs(token.RPAREN) { // check if no parameters 
 		p.nextToken()
-		return identifiers
+		return true
 	}
 
-	p.nextToken()
+	for {
+		p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal} // make a identifier
-	identifiers = append(identifiers, ident) // append to list
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if p.peekTokenIs(token.COMMA) {
+				p.errorAt(p.peekToken.Pos, "rest parameter %s must be the last parameter", lit.Rest.Value)
+				return false
+			}
+			break
+		}
 
-	for p.peekTokenIs(token.COMMA) { // go through whole list and append tokens
-		p.nextToken()
-		p.nextToken()
+		if !p.curTokenIs(token.IDENT) {
+			p.errorAt(p.curToken.Pos, "expected parameter name, got %s", p.curToken.Type)
+			return false
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
-	}
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+		var def ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			if def = p.parseExpression(LOWEST); def == nil {
+				return false
+			}
+		} else if lit.Required() < len(lit.Parameters) { // once one parameter has a default all the ones after it need one
+			p.errorAt(ident.Pos(), "parameter %s needs a default value, it follows one that has a default", ident.Value)
+			return false
+		}
+
+		lit.Parameters = append(lit.Parameters, ident)
+		lit.Defaults = append(lit.Defaults, def)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 
-	return identifiers
+	return p.expectPeek(token.RPAREN)
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
@@ -475,7 +729,12 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking && p.curTokenIs(token.RBRACE) { // the error left us on the brace closing this block
+			p.panicking = false
+			break
+		} else if p.panicking {
+			p.synchronize()
+		} else if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
@@ -563,17 +822,37 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseIllegal() ast.Expression { // the lexer puts the reason a token is illegal in its literal
+	p.errorAt(p.curToken.Pos, "%s", p.curToken.Literal)
+	return nil
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// Errors returns the message of every diagnostic prefixed with its position
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+
+	for _, d := range p.diagnostics {
+		errors = append(errors, fmt.Sprintf("%s: %s", d.Pos, d.Message))
+	}
+
+	return errors
+}
+
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.report(Diagnostic{
+		Pos: p.peekToken.Pos,
+		Message: fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type),
+		Expected: t,
+		Got: p.peekToken,
+	})
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -604,7 +883,9 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 	for p.curToken.Type != token.EOF {
 		stmt := p.parseStatement()
-		if stmt != nil {
+		if p.panicking {
+			p.synchronize()
+		} else if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
@@ -618,15 +899,27 @@ func (p *Parser) parseStatement() ast.Statement { // takes a statement from the
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	case token.BREAK:
-		return &ast.BreakStatement{ Token: p.curToken }
+		stmt := &ast.BreakStatement{ Token: p.curToken }
+		p.skipSemicolon()
+		return stmt
 	case token.CONTINUE:
-		return &ast.ContinueStatement{ Token: p.curToken }
+		stmt := &ast.ContinueStatement{ Token: p.curToken }
+		p.skipSemicolon()
+		return stmt
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
+func (p *Parser) skipSemicolon() {
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement { 
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -647,14 +940,28 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement { // fetches curren
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) { // skips over 
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
 	return stmt
 }
  
-func (p *Parser) parseLetStatement() *ast.LetStatement { 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLetStatement() ast.Statement { 
 	stmt := &ast.LetStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENT) {
@@ -663,6 +970,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	if p.peekTokenIs(token.COLON) {
+		return p.parseTypedLetStatement(stmt)
+	}
+
 	if !p.expectPeek(token.ASSIGN) {
 		return nil 
 	}
@@ -678,6 +989,80 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// parseTypedLetStatement finishes a let whose name is followed by a type annotation
+func (p *Parser) parseTypedLetStatement(let *ast.LetStatement) ast.Statement {
+	stmt := &ast.TypedLetStatement{Token: let.Token, Name: let.Name}
+
+	p.nextToken()
+	if !p.expectTypeName() {
+		return nil
+	}
+
+	stmt.Type = p.parseTypeAnnotation()
+	if stmt.Type == nil || !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseTypeAnnotation parses a type starting at its name, type parameters go between < and >
+func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
+	ta := &ast.TypeAnnotation{Token: p.curToken, Name: p.curToken.Literal}
+
+	if !p.peekTokenIs(token.LT) {
+		return ta
+	}
+	p.nextToken()
+
+	for {
+		if !p.expectTypeName() {
+			return nil
+		}
+
+		param := p.parseTypeAnnotation()
+		if param == nil {
+			return nil
+		}
+		ta.Params = append(ta.Params, param)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if p.peekTokenIs(token.SHIFT_RIGHT) { // array<array<int>> ends in >>, take the first > and leave the second
+		pos := p.peekToken.Pos
+		pos.Column++
+		p.peekToken = token.Token{Type: token.GT, Literal: ">", Pos: pos}
+		return ta
+	}
+
+	if !p.expectPeek(token.GT) {
+		return nil
+	}
+
+	return ta
+}
+
+// expectTypeName advances onto a type name, fn is a keyword but also the type of functions
+func (p *Parser) expectTypeName() bool {
+	if p.peekTokenIs(token.FUNCTION) {
+		p.nextToken()
+		return true
+	}
+	return p.expectPeek(token.IDENT)
+}
+
 func (p *Parser) curTokenIs(t token.TokenType) bool { // check for current token tupe
 	return p.curToken.Type == t
 }
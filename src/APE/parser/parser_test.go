@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"APE/ast"
 	"APE/lexer"
+	"APE/token"
 	"testing"
 	"strings"
 )
@@ -64,6 +65,106 @@ func TestAssignmentExpression(t *testing.T) {
  }
 }
 
+func TestIndexAssignmentExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"arr[0] = 5;", "(arr[0] = 5)"},
+		{`h["k"] = v + 1;`, `(h["k"] = (v + 1))`},
+		{`h["a"][0] = 1;`, `((h["a"])[0] = 1)`},
+		{"a[0] = b[1] = 2;", "(a[0] = (b[1] = 2))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.IndexAssignmentExpression); !ok {
+			t.Fatalf("stmt.Expression is not ast.IndexAssignmentExpression. got=%T", stmt.Expression)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := lexer.New("f() = 1;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error. got=%d (%q)", len(errors), errors)
+	}
+
+	if errors[0] != "1:5: expected identifier or index expression on left side of assignment, got *ast.CallExpression" {
+		t.Errorf("wrong error. got=%q", errors[0])
+	}
+}
+
+func TestFunctionDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+		required int
+	}{
+		{"fn(a, b = 2) { a }", "fn(a, b = 2) a", 1},
+		{"fn(a = 1, b = a + 1) { a }", "fn(a = 1, b = (a + 1)) a", 0},
+		{"fn(first, ...rest) { rest }", "fn(first, ...rest) rest", 1},
+		{"fn(...all) { all }", "fn(...all) all", 0},
+		{"fn(a, b = [1], ...rest) { a }", "fn(a, b = [1], ...rest) a", 1},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		fn, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("expression is not *ast.FunctionLiteral. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		if fn.String() != tt.expected {
+			t.Errorf("String() wrong. want=%q, got=%q", tt.expected, fn.String())
+		}
+		if fn.Required() != tt.required {
+			t.Errorf("Required() wrong. want=%d, got=%d", tt.required, fn.Required())
+		}
+	}
+}
+
+func TestInvalidFunctionParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"fn(a = 1, b) { a };", "1:11: parameter b needs a default value, it follows one that has a default"},
+		{"fn(...rest, b) { b };", "1:11: rest parameter rest must be the last parameter"},
+		{"fn(1) { 1 };", "1:4: expected parameter name, got INT"},
+		{"fn(...) { 1 };", "1:7: expected next token to be IDENT, got ) instead"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("%q - expected 1 error. got=%d (%q)", tt.input, len(errors), errors)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
 func TestTypedLetStatements(t *testing.T) {
 	tests := []struct {
 		input string 
@@ -74,7 +175,7 @@ func TestTypedLetStatements(t *testing.T) {
 		{"let x: int = 7;", "x", "int", 7}, 
 		{"let isDog: bool = true;", "isDog", "bool", true}, 
 		{"let dog: string = \"dog\";", "dog", "string", "dog"},
-		{"let c: char = 'c'; ", "c", "char", "c"}, 
+		{"let c: char = 'c'; ", "c", "char", 'c'}, 
 		{"let nums: array<int> = [1, 2, 3];", "nums", "array<int>", "[1, 2, 3]"}, 
 		{"let dictonary: hash<string, int> = {\"a\": 1}", "dictonary", "hash<string, int>", "{\"a\":1}"}, 
 	}
@@ -95,7 +196,7 @@ func TestTypedLetStatements(t *testing.T) {
 			t.Fatalf("program.Statements[0] is not ast.TypedLetStatement. got=%T", program.Statements[0])
 		}
 
-		if stmt.Name.Value != tt.expectedIdentifer {
+		if stmt.Name.Value != tt.expectedIdentifier {
 			t.Errorf("stmt.Name.Value not '%s'. got=%s", tt.expectedIdentifier, stmt.Name.Value)
 		}
 
@@ -147,6 +248,28 @@ func TestTypedLetStatements(t *testing.T) {
 }
 
 
+func TestNestedTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"let grid: array<array<int>> = [];", "let grid: array<array<int>> = [];"},
+		{"let deep: array<array<array<int>>> = [];", "let deep: array<array<array<int>>> = [];"},
+		{"let index: hash<string, array<int>> = {};", "let index: hash<string, array<int>> = {};"},
+		{"let f: fn = fn(x) { x };", "let f: fn = fn(x) x;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
 
 func TestForLoopParsing(t *testing.T) {
 	tests := []struct {
@@ -380,7 +503,7 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 			t.Errorf("key is not ast.StringLiteral. got=%T", key)
 		}
 
-		expectedValue := expected[literal.String()]
+		expectedValue := expected[literal.Value]
 
 		testIntegerLiteral(t, value, expectedValue)
 	}
@@ -450,6 +573,130 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestStringLiteralRoundTrip(t *testing.T) {
+	input := `let s = "tab\there \"quoted\" \\ \u{e9}\nend";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	// the printed program has to lex back to the same string
+	reparsed := New(lexer.New(program.String())).ParseProgram()
+
+	original := program.Statements[0].(*ast.LetStatement).Value.(*ast.StringLiteral)
+	again, ok := reparsed.Statements[0].(*ast.LetStatement).Value.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("printed program did not parse back. got=%q", program.String())
+	}
+
+	if original.Value != "tab\there \"quoted\" \\ \u00e9\nend" {
+		t.Errorf("literal.Value wrong. got=%q", original.Value)
+	}
+
+	if again.Value != original.Value {
+		t.Errorf("value changed after printing. want=%q, got=%q", original.Value, again.Value)
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		strings  []string
+		expected string
+	}{
+		{`"hello ${name}"`, []string{"hello ", ""}, `"hello ${name}"`},
+		{`"${a} and ${b * 2}!"`, []string{"", " and ", "!"}, `"${a} and ${(b * 2)}!"`},
+		{`"${user["name"]}"`, []string{"", ""}, `"${(user["name"])}"`},
+		{`"${"${x}"}\n\${y}"`, []string{"", "\n${y}"}, `"${"${x}"}\n\${y}"`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+
+		if len(str.Strings) != len(tt.strings) || len(str.Expressions) != len(tt.strings)-1 {
+			t.Fatalf("%s - wrong number of parts. got strings=%q, expressions=%d", tt.input, str.Strings, len(str.Expressions))
+		}
+		for i, s := range tt.strings {
+			if str.Strings[i] != s {
+				t.Errorf("%s - str.Strings[%d] wrong. want=%q, got=%q", tt.input, i, s, str.Strings[i])
+			}
+		}
+
+		if str.String() != tt.expected {
+			t.Errorf("%s - String() wrong. want=%s, got=%s", tt.input, tt.expected, str.String())
+		}
+	}
+}
+
+func TestInvalidInterpolation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a ${} b"`, "1:6: empty interpolation, expected an expression between ${ and }"},
+		{`"a ${x y} b"`, "1:8: expected } to close the interpolation, got IDENT instead"},
+		{`"a ${x} \q"`, `1:9: unknown escape sequence '\q'`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%s - expected an error", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("%s - wrong error. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestCharLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected rune
+		printed string
+	}{
+		{`'x'`, 'x', `'x'`},
+		{`'\''`, '\'', `'\''`},
+		{`'\t'`, '\t', `'\t'`},
+		{`'"'`, '"', `'"'`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.CharLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.CharLiteral. got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %q. got=%q", tt.expected, literal.Value)
+		}
+
+		if literal.String() != tt.printed {
+			t.Errorf("literal.String() not %s. got=%s", tt.printed, literal.String())
+		}
+	}
+}
 
 func TestParsinngIndexExpression(t *testing.T) { 
 	input := "myArray[1 + 1]"
@@ -643,6 +890,107 @@ func TestIntegerLiteralExpression(t *testing.T) {
 }
 
 
+func TestIntegerLiteralForms(t *testing.T) {
+	tests := []struct {
+		input string
+		expected int64
+	}{
+		{"0xff", 255},
+		{"0XFF", 255},
+		{"0o755", 493},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0x_7fff_ffff", 2147483647},
+		{"0b1111_0000", 240},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("did not recieve a IntegerLiteral type got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("%s - value wrong. want=%d, got=%d", tt.input, tt.expected, literal.Value)
+		}
+		if literal.String() != tt.input { // the literal is printed the way it was written
+			t.Errorf("%s - String() wrong. got=%s", tt.input, literal.String())
+		}
+	}
+
+	p := New(lexer.New("0x1_0000_0000_0000_0000; 1_000.25;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	big := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+	if big.Big == nil || big.Big.String() != "18446744073709551616" {
+		t.Errorf("big hex literal wrong. got=%v", big.Big)
+	}
+	float := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FloatLiteral)
+	if float.Value != 1000.25 {
+		t.Errorf("float with separators wrong. got=%v", float.Value)
+	}
+}
+
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	p := New(lexer.New("123456789012345678901234567890;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	literal, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("did not recieve a IntegerLiteral type got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+
+	if literal.Big == nil || literal.Big.String() != "123456789012345678901234567890" {
+		t.Errorf("literal.Big wrong. got=%v", literal.Big)
+	}
+
+	if literal.String() != "123456789012345678901234567890" {
+		t.Errorf("literal.String() wrong. got=%s", literal.String())
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{"2.5E+3;", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("Expected 1 Statement got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not an ExpressionStatement got=%T", program.Statements[0])
+		}
+
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("did not recieve a FloatLiteral type got=%T", stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
+
 func TestBooleanLiteralExpression(t *testing.T) {
     tests := []struct {
         input string
@@ -733,6 +1081,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a[1:b + 2]",
+			"(a[1:(b + 2)])",
+		},
+		{
+			"a[:2] + a[x:][:]",
+			"((a[:2]) + ((a[x:])[:]))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -817,6 +1173,42 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a == b && c != d || e",
+			"(((a == b) && (c != d)) || e)",
+		},
+		{
+			"a % b * c + d",
+			"(((a % b) * c) + d)",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)))",
+		},
+		{
+			"a & 1 == 0",
+			"((a & 1) == 0)",
+		},
+		{
+			"1 << a + b",
+			"(1 << (a + b))",
+		},
+		{
+			"a >> 1 & b < c",
+			"(((a >> 1) & b) < c)",
+		},
+		{
+			"~a & b",
+			"((~a) & b)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1085,3 +1477,191 @@ func TestLetStatements(t *testing.T) {
 		}
 		t.FailNow()
 	}
+
+func TestParserErrorPositions(t *testing.T) {
+	input := "let x = 5;\nlet = 10;"
+
+	l := lexer.NewFile("test.ape", input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+
+	expected := "test.ape:2:5: expected next token to be IDENT, got = instead"
+	if errors[0] != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, errors[0])
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input string
+		expectedErrors []string
+		expectedProgram string
+	}{
+		{
+			"let x = add(1, 2;\nlet y = 3;",
+			[]string{"1:17: expected next token to be ), got ; instead"},
+			"let y = 3;",
+		},
+		{
+			"if (x { y }\nlet z = 1;",
+			[]string{"1:7: expected next token to be ), got { instead"},
+			"let z = 1;",
+		},
+		{
+			"let f = fn() { let x = (1 + 2; x };\nlet g = 1;",
+			[]string{"1:30: expected next token to be ), got ; instead"},
+			"let f = fn() x;let g = 1;",
+		},
+		{
+			"fn() { x + }; let q = 2;",
+			[]string{"1:12: no prefix parse function for } found"},
+			"fn() let q = 2;",
+		},
+		{
+			"let = 10; let y = ; let z = 3;",
+			[]string{
+				"1:5: expected next token to be IDENT, got = instead",
+				"1:19: no prefix parse function for ; found",
+			},
+			"let z = 3;",
+		},
+	}
+
+	for i, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("test[%d] - wrong number of errors. expected=%d, got=%d (%q)", i, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+
+		for j, expected := range tt.expectedErrors {
+			if errors[j] != expected {
+				t.Errorf("test[%d] - wrong error. expected=%q, got=%q", i, expected, errors[j])
+			}
+		}
+
+		if program.String() != tt.expectedProgram {
+			t.Errorf("test[%d] - wrong program. expected=%q, got=%q", i, tt.expectedProgram, program.String())
+		}
+	}
+}
+
+func TestDiagnosticExpectedToken(t *testing.T) {
+	l := lexer.NewFile("test.ape", "let x 5;")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic. got=%d", len(diagnostics))
+	}
+
+	d := diagnostics[0]
+	if d.Expected != token.ASSIGN {
+		t.Errorf("d.Expected not %s. got=%s", token.ASSIGN, d.Expected)
+	}
+
+	if d.Got.Type != token.INT || d.Got.Literal != "5" {
+		t.Errorf("d.Got wrong. got=%+v", d.Got)
+	}
+
+	expected := "test.ape:1:7: error: expected next token to be =, got INT instead"
+	if d.String() != expected {
+		t.Errorf("d.String() wrong. expected=%q, got=%q", expected, d.String())
+	}
+}
+
+func TestTryExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{`try { x } catch (e) { y }`, "try x catch (e) y"},
+		{`try { x } finally { z }`, "try x finally z"},
+		{`try { throw x; } catch (err) { y } finally { z }`, "try throw x; catch (err) y finally z"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+
+		if _, ok := stmt.Expression.(*ast.TryExpression); !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestTryWithoutHandlerIsAnError(t *testing.T) {
+	l := lexer.New(`try { x }`)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error. got=%d (%q)", len(errors), errors)
+	}
+
+	if errors[0] != "1:1: try needs a catch or finally block" {
+		t.Errorf("wrong error. got=%q", errors[0])
+	}
+}
+
+func TestBreakAndContinueTakeSemicolons(t *testing.T) {
+	l := lexer.New(`while (true) { if (x) { continue; }; break; }`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+}
+
+func TestLexerErrorsAreReported(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"let x = 1; /* open", "1:12: unterminated block comment"},
+		{"let x = @;", "1:9: unexpected character '@'"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 error. got=%d (%q)", len(errors), errors)
+		}
+
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
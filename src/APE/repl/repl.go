@@ -5,18 +5,35 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"APE/compiler"
 	"APE/evaluator"
 	"APE/lexer"
 	"APE/object"
 	"APE/parser"
+	"APE/resolver"
+	"APE/typecheck"
+	"APE/vm"
 )
 
 const PROMPT = "APE >>"
 const CONT_PROMPT = "... "
 
-func Start(in io.Reader, out io.Writer) {
+// the engines a program can run on, the tree walking evaluator or the bytecode VM
+const (
+	ENGINE_EVAL = "eval"
+	ENGINE_VM = "vm"
+)
+
+func Start(in io.Reader, out io.Writer, engine string) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
+	res := resolver.New(evaluator.BuiltinNames()) // its global scope matches env
+
+	// the VM's state lives on between inputs the same way env does
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+
 	var inputBuffer strings.Builder
 	inBlock := false
 
@@ -51,15 +68,56 @@ func Start(in io.Reader, out io.Writer) {
 		l := lexer.New(input)
 		p := parser.New(l)
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if diagnostics := p.Diagnostics(); len(diagnostics) != 0 {
+			messages := []string{}
+			for _, d := range diagnostics {
+				messages = append(messages, d.String())
+			}
+			printParserErrors(out, messages)
+			inputBuffer.Reset()
+			continue
+		}
+
+		if typeErrors := typecheck.Check(program); len(typeErrors) != 0 {
+			messages := []string{}
+			for _, e := range typeErrors {
+				messages = append(messages, e.String())
+			}
+			printParserErrors(out, messages)
 			inputBuffer.Reset()
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		if resolveErrors := res.Resolve(program); len(resolveErrors) != 0 {
+			messages := []string{}
+			for _, e := range resolveErrors {
+				messages = append(messages, e.String())
+			}
+			printParserErrors(out, messages)
+			inputBuffer.Reset()
+			continue
+		}
+
+		var evaluated object.Object
+		if engine == ENGINE_VM {
+			comp := compiler.NewWithState(symbolTable, constants)
+			if err := comp.Compile(program); err != nil {
+				printParserErrors(out, []string{err.Error()})
+				inputBuffer.Reset()
+				continue
+			}
 
-		if evaluated != nil {
+			bytecode := comp.Bytecode()
+			constants = bytecode.Constants
+			evaluated = vm.NewWithGlobals(bytecode, globals).Run()
+		} else {
+			evaluated = evaluator.EvalSafely(program, env)
+		}
+
+		if errObj, ok := evaluated.(*object.Error); ok {
+			io.WriteString(out, errObj.Traceback())
+			io.WriteString(out, "\n")
+		} else if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
 		}
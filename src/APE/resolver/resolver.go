@@ -0,0 +1,255 @@
+package resolver
+
+import (
+	"fmt"
+	"APE/ast"
+	"APE/token"
+	"sort"
+)
+
+// Error is a name the program uses that no scope declares
+type Error struct {
+	Pos token.Position
+	Message string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// scope mirrors one environment the evaluator creates, every name declared in it gets the next free slot
+type scope struct {
+	outer *scope
+	slots map[string]int
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, slots: map[string]int{}}
+}
+
+// declare gives name a slot, a name declared again in the same scope keeps the slot it had
+func (s *scope) declare(name *ast.Identifier) {
+	slot, ok := s.slots[name.Value]
+	if !ok {
+		slot = len(s.slots)
+		s.slots[name.Value] = slot
+	}
+	name.Resolved, name.Depth, name.Slot = true, 0, slot
+}
+
+// pending is a function body waiting for the scope it was written in to be complete
+type pending struct {
+	fn *ast.FunctionLiteral
+	scope *scope
+}
+
+// Resolver works out where every variable lives so the evaluator can find it by index instead of searching
+// each enclosing scope by name. It keeps its global scope between programs the way the REPL keeps its environment.
+type Resolver struct {
+	scope *scope
+	builtins map[string]bool
+	pending []pending
+	errors []Error
+}
+
+func New(builtins []string) *Resolver {
+	r := &Resolver{scope: newScope(nil), builtins: map[string]bool{}}
+	for _, name := range builtins {
+		r.builtins[name] = true
+	}
+	return r
+}
+
+// Resolve annotates every identifier in program with its depth and slot and reports the ones that are never
+// declared. Function bodies are resolved once the function they are in has been, so they can call functions
+// declared after them.
+func (r *Resolver) Resolve(program *ast.Program) []Error {
+	r.errors = nil
+
+	r.statements(program.Statements)
+	r.flush()
+
+	sort.SliceStable(r.errors, func(i, j int) bool {
+		a, b := r.errors[i].Pos, r.errors[j].Pos
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return r.errors
+}
+
+func (r *Resolver) errorAt(pos token.Position, format string, a ...interface{}) {
+	r.errors = append(r.errors, Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (r *Resolver) enterScope() {
+	r.scope = newScope(r.scope)
+}
+
+func (r *Resolver) leaveScope() {
+	r.scope = r.scope.outer
+}
+
+// lookup finds the scope declaring name, counting how many scopes out it is
+func (r *Resolver) lookup(name *ast.Identifier) bool {
+	depth := 0
+	for s := r.scope; s != nil; s = s.outer {
+		if slot, ok := s.slots[name.Value]; ok {
+			name.Resolved, name.Depth, name.Slot = true, depth, slot
+			return true
+		}
+		depth++
+	}
+
+	name.Resolved = false
+	return false
+}
+
+func (r *Resolver) flush() {
+	for len(r.pending) > 0 {
+		p := r.pending[0]
+		r.pending = r.pending[1:]
+		r.function(p.fn, p.scope)
+	}
+}
+
+// function resolves a function body in a scope of its own, the parameters and the lets of the body share it
+func (r *Resolver) function(fn *ast.FunctionLiteral, outer *scope) {
+	prevScope, prevPending := r.scope, r.pending
+	r.scope, r.pending = newScope(outer), nil
+
+	for _, p := range fn.Parameters {
+		r.scope.declare(p)
+	}
+	if fn.Rest != nil {
+		r.scope.declare(fn.Rest)
+	}
+	for i := range fn.Parameters {
+		r.expression(fn.Default(i))
+	}
+	if fn.Body != nil {
+		r.statements(fn.Body.Statements)
+	}
+	r.flush()
+	fn.Locals = len(r.scope.slots)
+
+	r.scope, r.pending = prevScope, prevPending
+}
+
+func (r *Resolver) statements(statements []ast.Statement) {
+	for _, s := range statements {
+		r.statement(s)
+	}
+}
+
+func (r *Resolver) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		r.expression(s.Value)
+		r.scope.declare(s.Name)
+	case *ast.TypedLetStatement:
+		r.expression(s.Value)
+		r.scope.declare(s.Name)
+	case *ast.ReturnStatement:
+		r.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(s.Value)
+	case *ast.ExpressionStatement:
+		r.expression(s.Expression)
+	case *ast.BlockStatement:
+		r.block(s)
+	}
+}
+
+func (r *Resolver) block(b *ast.BlockStatement) {
+	if b == nil {
+		return
+	}
+
+	r.enterScope()
+	r.statements(b.Statements)
+	r.leaveScope()
+}
+
+func (r *Resolver) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if r.lookup(exp) {
+			return
+		}
+		if r.builtins[exp.Value] {
+			exp.Resolved, exp.Depth, exp.Slot = true, -1, 0
+			return
+		}
+		r.errorAt(exp.Pos(), "identifier not found: %s", exp.Value)
+	case *ast.AssignmentExpression:
+		r.expression(exp.Value)
+		if !r.lookup(exp.Name) {
+			r.errorAt(exp.Pos(), "assignment to undeclared variable: %s", exp.Name.Value)
+		}
+	case *ast.IndexAssignmentExpression:
+		r.expression(exp.Target.Left)
+		r.expression(exp.Target.Index)
+		r.expression(exp.Value)
+	case *ast.FunctionLiteral:
+		r.pending = append(r.pending, pending{fn: exp, scope: r.scope})
+	case *ast.ArrayLiteral:
+		for _, el := range exp.Elements {
+			r.expression(el)
+		}
+	case *ast.HashLiteral:
+		for k, v := range exp.Pairs {
+			r.expression(k)
+			r.expression(v)
+		}
+	case *ast.InterpolatedString:
+		for _, e := range exp.Expressions {
+			r.expression(e)
+		}
+	case *ast.PrefixExpression:
+		r.expression(exp.Right)
+	case *ast.InfixExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Right)
+	case *ast.IfExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Consequence)
+		r.block(exp.Alternative)
+	case *ast.WhileExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Body)
+	case *ast.ForExpression:
+		r.enterScope()
+		if exp.Init != nil {
+			r.statement(exp.Init)
+		}
+		r.expression(exp.Condition)
+		r.expression(exp.Update)
+		r.block(exp.Body)
+		r.leaveScope()
+	case *ast.TryExpression:
+		r.block(exp.Block)
+		if exp.Catch != nil {
+			r.enterScope()
+			r.scope.declare(exp.Param)
+			r.block(exp.Catch)
+			r.leaveScope()
+		}
+		r.block(exp.Finally)
+	case *ast.CallExpression:
+		r.expression(exp.Function)
+		for _, a := range exp.Arguments {
+			r.expression(a)
+		}
+	case *ast.MethodCallExpression:
+		r.expression(exp.Object)
+		for _, a := range exp.Arguments {
+			r.expression(a)
+		}
+	case *ast.IndexExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Index)
+	case *ast.SliceExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Low)
+		r.expression(exp.High)
+	}
+}
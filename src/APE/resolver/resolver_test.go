@@ -0,0 +1,107 @@
+package resolver
+
+import (
+	"APE/ast"
+	"APE/lexer"
+	"APE/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		expected []string
+	}{
+		{"let a = 1; a + len(\"x\")", nil},
+		{"let f = fn() { g() }; let g = fn() { 1 };", nil},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };", nil},
+		{"let x = 1; x = 2;", nil},
+		{"missing", []string{"1:1: identifier not found: missing"}},
+		{"let f = fn() { nope };", []string{"1:16: identifier not found: nope"}},
+		{"y = 5", []string{"1:3: assignment to undeclared variable: y"}},
+		{"let x = x + 1;", []string{"1:9: identifier not found: x"}},
+		{"if (true) { let inner = 1 }; inner", []string{"1:30: identifier not found: inner"}},
+		{"for (let i = 0; i < 3; i = i + 1) { i }; i", []string{"1:42: identifier not found: i"}},
+		{"try { throw 1 } catch (e) { e }; e", []string{"1:34: identifier not found: e"}},
+		{"let f = fn() { b }; a; let g = fn() { c };", []string{
+			"1:16: identifier not found: b",
+			"1:21: identifier not found: a",
+			"1:39: identifier not found: c",
+		}},
+	}
+
+	for _, tt := range tests {
+		errors := New([]string{"len"}).Resolve(parse(t, tt.input))
+
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q - wrong number of errors. want=%v, got=%v", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, e := range errors {
+			if e.String() != tt.expected[i] {
+				t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.expected[i], e.String())
+			}
+		}
+	}
+}
+
+func TestResolveSlots(t *testing.T) {
+	program := parse(t, "let a = 1; let b = 2; let f = fn(x) { let y = x; if (true) { a + y } }; len")
+	New([]string{"len"}).Resolve(program)
+
+	checkIdent := func(name string, ident *ast.Identifier, depth, slot int) {
+		t.Helper()
+		if !ident.Resolved || ident.Depth != depth || ident.Slot != slot {
+			t.Errorf("%s resolved wrong. want=(%d, %d), got resolved=%t (%d, %d)",
+				name, depth, slot, ident.Resolved, ident.Depth, ident.Slot)
+		}
+	}
+
+	checkIdent("b", program.Statements[1].(*ast.LetStatement).Name, 0, 1)
+
+	fn := program.Statements[2].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if fn.Locals != 2 {
+		t.Errorf("fn.Locals wrong. want=2, got=%d", fn.Locals)
+	}
+	checkIdent("x", fn.Parameters[0], 0, 0)
+
+	let := fn.Body.Statements[0].(*ast.LetStatement)
+	checkIdent("y", let.Name, 0, 1)
+	checkIdent("x in y's value", let.Value.(*ast.Identifier), 0, 0)
+
+	ifExp := fn.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	sum := ifExp.Consequence.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	checkIdent("a", sum.Left.(*ast.Identifier), 2, 0)
+	checkIdent("y in the block", sum.Right.(*ast.Identifier), 1, 1)
+
+	checkIdent("len", program.Statements[3].(*ast.ExpressionStatement).Expression.(*ast.Identifier), -1, 0)
+}
+
+func TestResolverKeepsGlobals(t *testing.T) {
+	r := New(nil)
+
+	if errors := r.Resolve(parse(t, "let a = 1; let b = 2;")); len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	program := parse(t, "b")
+	if errors := r.Resolve(program); len(errors) != 0 {
+		t.Fatalf("globals from the first program were forgotten: %v", errors)
+	}
+
+	ident := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.Identifier)
+	if ident.Depth != 0 || ident.Slot != 1 {
+		t.Errorf("b resolved wrong. want=(0, 1), got=(%d, %d)", ident.Depth, ident.Slot)
+	}
+}
@@ -1,11 +1,37 @@
 package token
 
+import "fmt"
+
 // Make a struct of a token 
 type TokenType string
 
 type Token struct {
 	Type TokenType // Allows us to determine the type of token.
 	Literal string
+	Pos Position // where the token starts in the source
+	Comments []string // comments directly before the token, kept so a formatter can put them back
+}
+
+// Position is a location in a source file, lines and columns start at 1
+type Position struct {
+	Filename string
+	Line int
+	Column int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String formats the position as file:line:column, leaving out the file name when there isn't one
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 func LookupIdent(ident string) TokenType {
@@ -27,20 +53,29 @@ var keywords = map[string]TokenType{ // a collection of all valid keyword types
 	"for": FOR, 
 	"break": BREAK,
 	"continue": CONTINUE, 
+	"try": TRY,
+	"catch": CATCH,
+	"finally": FINALLY,
+	"throw": THROW,
 }
 
 // Types of identifiers that our token will recognise 
 const (
 	// Special tokens 
-	ILLEGAL = "ILLEGAL"
+	ILLEGAL = "ILLEGAL" // the literal of an illegal token describes what is wrong
 	EOF = "EOF"
 
 	// Identifiers and literal tokens 
     IDENT = "IDENT" // Variable names x, y, z 
 	INT = "INT" // Numbers: 12345
+	FLOAT = "FLOAT" // Numbers with a fraction or exponent: 3.14, 1e-9
 
 	// types
 	STRING = "STRING"
+	TEMPLATE_HEAD = "TEMPLATE_HEAD" // the text of a template string before its first ${
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE" // the text between the } of one interpolation and the next ${
+	TEMPLATE_TAIL = "TEMPLATE_TAIL" // the text after the last interpolation up to the closing quote
+	CHAR = "CHAR" // a single quoted character, the literal holds the decoded character
 	
 
 	LBRACKET = "["
@@ -54,12 +89,22 @@ const (
 	BANG = "!"
 	SINGLE_BAR = "|"
 	OR = "||"
+	AND = "&&"
+	AMPERSAND = "&"
+	CARET = "^"
+	TILDE = "~"
+	SHIFT_LEFT = "<<"
+	SHIFT_RIGHT = ">>"
+	PERCENT = "%"
 	EQ = "=="
 	NOT_EQ = "!="
 	ASTERISK = "*"
 	DOT = "."
+	ELLIPSIS = "..."
 	LT = "<"
 	GT = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 	COLON = ":"
 
 	// Delimiters
@@ -84,4 +129,8 @@ const (
 	RETURN = "RETURN"
 	BREAK = "BREAK"
 	CONTINUE = "CONTINUE"
+	TRY = "TRY"
+	CATCH = "CATCH"
+	FINALLY = "FINALLY"
+	THROW = "THROW"
 )
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"APE/lexer"
+	"APE/token"
+)
+
+// jsonToken is how `ape tokens -json` writes a token, one object per line
+type jsonToken struct {
+	Type token.TokenType `json:"type"`
+	Literal string `json:"literal"`
+	Line int `json:"line"`
+	Column int `json:"column"`
+	Comments []string `json:"comments,omitempty"`
+}
+
+// tokensCommand runs `ape tokens [-json] file.ape`, printing every token the lexer reads from the file
+func tokensCommand(args []string) {
+	flags := flag.NewFlagSet("tokens", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print each token as a JSON object on its own line")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Printf("Usage: ape tokens [-json] file.ape\n")
+		return
+	}
+	filename := flags.Arg(0)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Printf("Error reading file: %s\n", err)
+		return
+	}
+	defer file.Close()
+
+	if err := dumpTokens(os.Stdout, lexer.NewReader(filename, file), *asJSON); err != nil {
+		fmt.Printf("Error reading file: %s\n", err)
+	}
+}
+
+// dumpTokens writes each token as it is read, up to and including EOF, so the file is never held in memory
+func dumpTokens(out io.Writer, l *lexer.Lexer, asJSON bool) error {
+	enc := json.NewEncoder(out)
+
+	for {
+		tok := l.NextToken()
+
+		var err error
+		if asJSON {
+			err = enc.Encode(jsonToken{
+				Type: tok.Type,
+				Literal: tok.Literal,
+				Line: tok.Pos.Line,
+				Column: tok.Pos.Column,
+				Comments: tok.Comments,
+			})
+		} else {
+			_, err = fmt.Fprintf(out, "%s\t%s\t%q\n", tok.Pos, tok.Type, tok.Literal)
+		}
+		if err != nil {
+			return err
+		}
+
+		if tok.Type == token.EOF {
+			return l.Err()
+		}
+	}
+}
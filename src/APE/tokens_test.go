@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"APE/lexer"
+)
+
+func TestDumpTokens(t *testing.T) {
+	input := "// greet\nlet größe = \"hi\";"
+
+	tests := []struct {
+		asJSON bool
+		expected string
+	}{
+		{false, `a.ape:2:1	LET	"let"
+a.ape:2:5	IDENT	"größe"
+a.ape:2:11	=	"="
+a.ape:2:13	STRING	"hi"
+a.ape:2:17	;	";"
+a.ape:2:18	EOF	""
+`},
+		{true, `{"type":"LET","literal":"let","line":2,"column":1,"comments":["// greet"]}
+{"type":"IDENT","literal":"größe","line":2,"column":5}
+{"type":"=","literal":"=","line":2,"column":11}
+{"type":"STRING","literal":"hi","line":2,"column":13}
+{"type":";","literal":";","line":2,"column":17}
+{"type":"EOF","literal":"","line":2,"column":18}
+`},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		l := lexer.NewReader("a.ape", iotest.OneByteReader(strings.NewReader(input)))
+
+		if err := dumpTokens(&out, l, tt.asJSON); err != nil {
+			t.Fatalf("json=%t - unexpected error: %v", tt.asJSON, err)
+		}
+
+		if out.String() != tt.expected {
+			t.Errorf("json=%t - output wrong. expected=\n%s\ngot=\n%s", tt.asJSON, tt.expected, out.String())
+		}
+	}
+}
+
+func TestDumpTokensReadError(t *testing.T) {
+	failure := errors.New("disk gone")
+	l := lexer.NewReader("a.ape", io.MultiReader(strings.NewReader("x"), iotest.ErrReader(failure)))
+
+	var out bytes.Buffer
+	if err := dumpTokens(&out, l, false); err != failure {
+		t.Errorf("error wrong. want=%v, got=%v", failure, err)
+	}
+
+	if expected := "a.ape:1:1\tIDENT\t\"x\"\na.ape:1:2\tEOF\t\"\"\n"; out.String() != expected {
+		t.Errorf("output wrong. expected=%q, got=%q", expected, out.String())
+	}
+}
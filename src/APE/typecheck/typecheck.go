@@ -0,0 +1,432 @@
+package typecheck
+
+import (
+	"fmt"
+	"APE/ast"
+	"APE/object"
+	"APE/token"
+)
+
+// Error is a type mismatch found before the program runs
+type Error struct {
+	Pos token.Position
+	Message string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%s: type error: %s", e.Pos, e.Message)
+}
+
+// typeParams is how many type parameters each known type takes
+var typeParams = map[string]int{
+	"int": 0,
+	"float": 0,
+	"bool": 0,
+	"string": 0,
+	"char": 0,
+	"fn": 0,
+	"any": 0,
+	"array": 1,
+	"hash": 2,
+}
+
+var (
+	INT = &ast.TypeAnnotation{Name: "int"}
+	FLOAT = &ast.TypeAnnotation{Name: "float"}
+	BOOL = &ast.TypeAnnotation{Name: "bool"}
+	STRING = &ast.TypeAnnotation{Name: "string"}
+	CHAR = &ast.TypeAnnotation{Name: "char"}
+	FN = &ast.TypeAnnotation{Name: "fn"}
+	ANY = &ast.TypeAnnotation{Name: "any"}
+)
+
+// scope holds the declared type of every variable, nil for variables declared without one
+type scope struct {
+	outer *scope
+	vars map[string]*ast.TypeAnnotation
+}
+
+func (s *scope) lookup(name string) *ast.TypeAnnotation {
+	for sc := s; sc != nil; sc = sc.outer {
+		if typ, ok := sc.vars[name]; ok {
+			return typ
+		}
+	}
+	return nil
+}
+
+type checker struct {
+	scope *scope
+	errors []Error
+}
+
+// Check reports every typed binding the program gives a value of the wrong type. Types it cannot work out
+// statically, like the result of a call, are left to the runtime checks.
+func Check(program *ast.Program) []Error {
+	c := &checker{scope: &scope{vars: map[string]*ast.TypeAnnotation{}}}
+
+	for _, s := range program.Statements {
+		c.statement(s)
+	}
+
+	return c.errors
+}
+
+func (c *checker) errorAt(pos token.Position, format string, a ...interface{}) {
+	c.errors = append(c.errors, Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *checker) enterScope() {
+	c.scope = &scope{outer: c.scope, vars: map[string]*ast.TypeAnnotation{}}
+}
+
+func (c *checker) leaveScope() {
+	c.scope = c.scope.outer
+}
+
+func (c *checker) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		c.scope.vars[s.Name.Value] = nil
+		c.expression(s.Value)
+	case *ast.TypedLetStatement:
+		if c.validate(s.Type) {
+			c.scope.vars[s.Name.Value] = s.Type
+			c.checkAssignable(s.Value, s.Name.Value, s.Type)
+		} else {
+			c.scope.vars[s.Name.Value] = nil
+			c.expression(s.Value)
+		}
+	case *ast.ReturnStatement:
+		c.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		c.expression(s.Value)
+	case *ast.ExpressionStatement:
+		c.expression(s.Expression)
+	case *ast.BlockStatement:
+		c.block(s)
+	}
+}
+
+func (c *checker) block(b *ast.BlockStatement) {
+	if b == nil {
+		return
+	}
+
+	c.enterScope()
+	for _, s := range b.Statements {
+		c.statement(s)
+	}
+	c.leaveScope()
+}
+
+// checkAssignable checks value can be stored in name, declared as typ
+func (c *checker) checkAssignable(value ast.Expression, name string, typ *ast.TypeAnnotation) {
+	valueType := c.expression(value)
+	if valueType != nil && !assignable(valueType, typ) {
+		c.errorAt(value.Pos(), "cannot assign %s to %s (%s)", valueType, name, typ)
+	}
+}
+
+// validate reports annotations naming unknown types or with the wrong number of type parameters
+func (c *checker) validate(typ *ast.TypeAnnotation) bool {
+	n, ok := typeParams[typ.Name]
+	if !ok {
+		c.errorAt(typ.Pos(), "unknown type %s", typ.Name)
+		return false
+	}
+
+	if len(typ.Params) != n {
+		c.errorAt(typ.Pos(), "%s takes %d type parameters, got %d", typ.Name, n, len(typ.Params))
+		return false
+	}
+
+	valid := true
+	for _, p := range typ.Params {
+		valid = c.validate(p) && valid
+	}
+	return valid
+}
+
+// expression checks exp and returns its type, or nil when it can only be known at runtime
+func (c *checker) expression(exp ast.Expression) *ast.TypeAnnotation {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return INT
+	case *ast.FloatLiteral:
+		return FLOAT
+	case *ast.StringLiteral:
+		return STRING
+	case *ast.InterpolatedString:
+		for _, e := range exp.Expressions {
+			c.expression(e)
+		}
+		return STRING
+	case *ast.CharLiteral:
+		return CHAR
+	case *ast.Boolean:
+		return BOOL
+	case *ast.Identifier:
+		return c.scope.lookup(exp.Value)
+
+	case *ast.ArrayLiteral:
+		var elem *ast.TypeAnnotation
+		for i, el := range exp.Elements {
+			t := c.expression(el)
+			if i == 0 {
+				elem = t
+			} else if elem != nil && (t == nil || elem.String() != t.String()) {
+				elem = ANY
+			}
+		}
+		if elem == nil {
+			elem = ANY
+		}
+		return &ast.TypeAnnotation{Name: "array", Params: []*ast.TypeAnnotation{elem}}
+
+	case *ast.HashLiteral:
+		var key, value *ast.TypeAnnotation
+		first := true
+		for k, v := range exp.Pairs {
+			kt, vt := c.expression(k), c.expression(v)
+			if first {
+				key, value, first = kt, vt, false
+				continue
+			}
+			key, value = unify(key, kt), unify(value, vt)
+		}
+		if key == nil {
+			key = ANY
+		}
+		if value == nil {
+			value = ANY
+		}
+		return &ast.TypeAnnotation{Name: "hash", Params: []*ast.TypeAnnotation{key, value}}
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+		for _, p := range exp.Parameters {
+			c.scope.vars[p.Value] = nil
+		}
+		if exp.Rest != nil {
+			c.scope.vars[exp.Rest.Value] = nil
+		}
+		for i := range exp.Parameters {
+			c.expression(exp.Default(i))
+		}
+		c.block(exp.Body)
+		c.leaveScope()
+		return FN
+
+	case *ast.PrefixExpression:
+		right := c.expression(exp.Right)
+		switch exp.Operator {
+		case "!":
+			return BOOL
+		case "-":
+			if isNamed(right, "int") || isNamed(right, "float") {
+				return right
+			}
+		case "~":
+			if isNamed(right, "int") {
+				return INT
+			}
+		}
+		return nil
+
+	case *ast.InfixExpression:
+		return infixType(exp.Operator, c.expression(exp.Left), c.expression(exp.Right))
+
+	case *ast.AssignmentExpression:
+		typ := c.scope.lookup(exp.Name.Value)
+		if typ == nil {
+			return c.expression(exp.Value)
+		}
+		c.checkAssignable(exp.Value, exp.Name.Value, typ)
+		return typ
+
+	case *ast.IndexAssignmentExpression:
+		container := c.expression(exp.Target.Left)
+		index := c.expression(exp.Target.Index)
+		value := c.expression(exp.Value)
+
+		// elements of a typed array or hash have to keep its element types
+		target := exp.Target.Left.String() + "[" + exp.Target.Index.String() + "]"
+		switch {
+		case isNamed(container, "array") && value != nil && !assignable(value, container.Params[0]):
+			c.errorAt(exp.Value.Pos(), "cannot assign %s to %s (%s)", value, target, container.Params[0])
+		case isNamed(container, "hash") && index != nil && !assignable(index, container.Params[0]):
+			c.errorAt(exp.Target.Index.Pos(), "cannot use %s as key of %s (%s)", index, exp.Target.Left, container)
+		case isNamed(container, "hash") && value != nil && !assignable(value, container.Params[1]):
+			c.errorAt(exp.Value.Pos(), "cannot assign %s to %s (%s)", value, target, container.Params[1])
+		}
+		return value
+
+	case *ast.IfExpression:
+		c.expression(exp.Condition)
+		c.block(exp.Consequence)
+		c.block(exp.Alternative)
+	case *ast.WhileExpression:
+		c.expression(exp.Condition)
+		c.block(exp.Body)
+	case *ast.ForExpression:
+		c.enterScope()
+		if exp.Init != nil {
+			c.statement(exp.Init)
+		}
+		c.expression(exp.Condition)
+		c.expression(exp.Update)
+		c.block(exp.Body)
+		c.leaveScope()
+	case *ast.TryExpression:
+		c.block(exp.Block)
+		if exp.Catch != nil {
+			c.enterScope()
+			c.scope.vars[exp.Param.Value] = nil
+			c.block(exp.Catch)
+			c.leaveScope()
+		}
+		c.block(exp.Finally)
+	case *ast.CallExpression:
+		c.expression(exp.Function)
+		for _, a := range exp.Arguments {
+			c.expression(a)
+		}
+	case *ast.MethodCallExpression:
+		c.expression(exp.Object)
+		for _, a := range exp.Arguments {
+			c.expression(a)
+		}
+	case *ast.IndexExpression:
+		c.expression(exp.Left)
+		c.expression(exp.Index)
+	case *ast.SliceExpression:
+		left := c.expression(exp.Left)
+		c.expression(exp.Low)
+		c.expression(exp.High)
+		if isNamed(left, "string") || isNamed(left, "array") { // a slice has the type of what it was taken from
+			return left
+		}
+	}
+
+	return nil
+}
+
+func infixType(operator string, left, right *ast.TypeAnnotation) *ast.TypeAnnotation {
+	switch operator {
+	case "<", ">", "<=", ">=", "==", "!=", "&&", "||":
+		return BOOL
+	case "&", "|", "^", "<<", ">>":
+		if isNamed(left, "int") && isNamed(right, "int") {
+			return INT
+		}
+		return nil
+	}
+
+	if operator == "+" && isText(left) && isText(right) {
+		return STRING
+	}
+
+	if isNamed(left, "int") && isNamed(right, "int") {
+		return INT
+	}
+	if isNumber(left) && isNumber(right) {
+		return FLOAT
+	}
+	return nil
+}
+
+func isNamed(t *ast.TypeAnnotation, name string) bool {
+	return t != nil && t.Name == name
+}
+
+func isText(t *ast.TypeAnnotation) bool {
+	return isNamed(t, "string") || isNamed(t, "char")
+}
+
+func isNumber(t *ast.TypeAnnotation) bool {
+	return isNamed(t, "int") || isNamed(t, "float")
+}
+
+// unify is the type that covers both a and b, any when they differ
+func unify(a, b *ast.TypeAnnotation) *ast.TypeAnnotation {
+	if a == nil || b == nil || a.String() != b.String() {
+		return ANY
+	}
+	return a
+}
+
+// assignable reports whether a value of type value can be stored in a binding declared as typ, any on either
+// side matches everything
+func assignable(value, typ *ast.TypeAnnotation) bool {
+	if typ.Name == "any" || value.Name == "any" {
+		return true
+	}
+
+	if value.Name != typ.Name || len(value.Params) != len(typ.Params) {
+		return false
+	}
+
+	for i := range typ.Params {
+		if !assignable(value.Params[i], typ.Params[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ElementType is the type of the elements of an array type or of the values of a hash type, nil for any other type
+func ElementType(t *ast.TypeAnnotation) *ast.TypeAnnotation {
+	if t == nil || (t.Name != "array" && t.Name != "hash") || len(t.Params) == 0 {
+		return nil
+	}
+	return t.Params[len(t.Params)-1]
+}
+
+// Matches reports whether obj is a value of type typ, the runtime check for typed bindings
+func Matches(obj object.Object, typ *ast.TypeAnnotation) bool {
+	switch typ.Name {
+	case "any":
+		return true
+	case "int":
+		return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+	case "float":
+		return obj.Type() == object.FLOAT_OBJ
+	case "bool":
+		return obj.Type() == object.BOOLEAN_OBJ
+	case "string":
+		return obj.Type() == object.STRING_OBJ
+	case "char":
+		return obj.Type() == object.CHAR_OBJ
+	case "fn":
+		switch obj.Type() {
+		case object.FUNCTION_OBJ, object.BUILTIN_OBJ, object.CLOSURE_OBJ:
+			return true
+		}
+		return false
+	case "array":
+		arr, ok := obj.(*object.Array)
+		if !ok || len(typ.Params) != 1 {
+			return false
+		}
+		for _, el := range arr.Elements {
+			if !Matches(el, typ.Params[0]) {
+				return false
+			}
+		}
+		return true
+	case "hash":
+		hash, ok := obj.(*object.Hash)
+		if !ok || len(typ.Params) != 2 {
+			return false
+		}
+		for _, pair := range hash.Pairs {
+			if !Matches(pair.Key, typ.Params[0]) || !Matches(pair.Value, typ.Params[1]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
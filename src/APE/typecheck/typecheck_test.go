@@ -0,0 +1,96 @@
+package typecheck
+
+import (
+	"APE/ast"
+	"APE/lexer"
+	"APE/object"
+	"APE/parser"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input string
+		expected []string
+	}{
+		{"let x: int = 7;", nil},
+		{"let x: float = 1.5; let y: bool = x > 1;", nil},
+		{"let nums: array<int> = [1, 2, 3];", nil},
+		{"let empty: array<string> = [];", nil},
+		{`let h: hash<string, int> = {"a": 1, "b": 2};`, nil},
+		{"let f: fn = fn(x) { x };", nil},
+		{"let anything: any = [1, \"a\"];", nil},
+		{"let f = fn() { 1 }; let x: string = f();", nil}, // only known at runtime
+		{`let x: int = "seven";`, []string{"1:14: type error: cannot assign string to x (int)"}},
+		{`let n = 7; let x: int = "${n}";`, []string{"1:25: type error: cannot assign string to x (int)"}},
+		{`let s: string = "abc"; let x: int = s[1:];`, []string{"1:38: type error: cannot assign string to x (int)"}},
+		{`let a: array<int> = [1]; let b: array<int> = a[:1];`, nil},
+		{`let s: string = "${1}"; "${s = 2}";`, []string{"1:32: type error: cannot assign int to s (string)"}},
+		{"let x: int = 1; x = true;", []string{"1:21: type error: cannot assign bool to x (int)"}},
+		{"let x: int = 1.5;", []string{"1:14: type error: cannot assign float to x (int)"}},
+		{`let nums: array<int> = [1, "two"];`, nil},
+		{`let words: array<string> = [1, 2];`, []string{"1:28: type error: cannot assign array<int> to words (array<string>)"}},
+		{`let h: hash<string, int> = {1: 1};`, []string{"1:28: type error: cannot assign hash<int, int> to h (hash<string, int>)"}},
+		{"let s: string = 1 + 2;", []string{"1:19: type error: cannot assign int to s (string)"}},
+		{"let x: int = 1; let f = fn() { x = \"a\" };", []string{"1:36: type error: cannot assign string to x (int)"}},
+		{"let x: int = 1; let f = fn(x) { x = \"a\" };", nil}, // the parameter shadows the typed x
+		{"let x: int = 1; let x = \"a\"; x = true;", nil},
+		{"let x: int = 1; if (true) { let x = \"a\"; x = \"b\" }; x = 2;", nil},
+		{"let x: int = 1; if (true) { let x = \"a\" }; x = \"b\";", []string{"1:48: type error: cannot assign string to x (int)"}},
+		{"let c: char = 'c'; let s: string = c + \"d\";", nil},
+		{"let c: char = \"c\";", []string{"1:15: type error: cannot assign string to c (char)"}},
+		{"let nums: array<int> = [1]; nums[0] = 2;", nil},
+		{`let nums: array<int> = [1]; nums[0] = "two";`, []string{`1:39: type error: cannot assign string to nums[0] (int)`}},
+		{`let h: hash<string, int> = {}; h["a"] = true;`, []string{`1:41: type error: cannot assign bool to h["a"] (int)`}},
+		{`let h: hash<string, int> = {}; h[1] = 1;`, []string{`1:34: type error: cannot use int as key of h (hash<string, int>)`}},
+		{"let x: number = 1;", []string{"1:8: type error: unknown type number"}},
+		{"let x: array = [];", []string{"1:8: type error: array takes 1 type parameters, got 0"}},
+		{"let x: array<array<int>> = [[1], [2]];", nil},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+
+		errors := Check(program)
+
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q - wrong number of errors. want=%d, got=%d (%v)", tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+
+		for i, e := range errors {
+			if e.String() != tt.expected[i] {
+				t.Errorf("%q - wrong error. want=%q, got=%q", tt.input, tt.expected[i], e.String())
+			}
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	intArray := &ast.TypeAnnotation{Name: "array", Params: []*ast.TypeAnnotation{INT}}
+
+	tests := []struct {
+		obj object.Object
+		typ *ast.TypeAnnotation
+		expected bool
+	}{
+		{&object.Integer{Value: 1}, INT, true},
+		{&object.Integer{Value: 1}, FLOAT, false},
+		{&object.String{Value: "a"}, STRING, true},
+		{&object.String{Value: "a"}, ANY, true},
+		{&object.Array{Elements: []object.Object{&object.Integer{Value: 1}}}, intArray, true},
+		{&object.Array{Elements: []object.Object{&object.String{Value: "a"}}}, intArray, false},
+		{&object.Array{}, intArray, true},
+		{&object.Builtin{}, FN, true},
+	}
+
+	for _, tt := range tests {
+		if got := Matches(tt.obj, tt.typ); got != tt.expected {
+			t.Errorf("Matches(%s, %s) wrong. want=%t, got=%t", tt.obj.Inspect(), tt.typ, tt.expected, got)
+		}
+	}
+}
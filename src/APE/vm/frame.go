@@ -0,0 +1,65 @@
+package vm
+
+import (
+	"APE/code"
+	"APE/object"
+	"APE/token"
+)
+
+// Frame is a running call, locals live on the heap so closures created in it can share them
+type Frame struct {
+	cl *object.Closure
+	ip int
+	basePointer int
+	locals []object.Object
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	f := &Frame{cl: cl, ip: -1, basePointer: basePointer}
+	if cl.Fn.NumLocals > 0 {
+		f.locals = make([]object.Object, cl.Fn.NumLocals)
+	}
+	return f
+}
+
+// cell holds a local that closures capture, every closure keeps the cell the local was in when it was created
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+func (c *cell) Inspect() string {
+	if c.value == nil {
+		return "cell()"
+	}
+	return "cell(" + c.value.Inspect() + ")"
+}
+
+// load reads a local, looking inside its cell when it has one
+func load(locals []object.Object, index int) object.Object {
+	if c, ok := locals[index].(*cell); ok {
+		return c.value
+	}
+	return locals[index]
+}
+
+// store writes a local, into its cell when it has one so every closure sharing the cell sees the value
+func store(locals []object.Object, index int, val object.Object) {
+	if c, ok := locals[index].(*cell); ok {
+		c.value = val
+		return
+	}
+	locals[index] = val
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// Pos is the source position of the instruction the frame is executing
+func (f *Frame) Pos() token.Position {
+	if f.cl.Fn.SourceMap == nil || f.ip < 0 {
+		return token.Position{}
+	}
+	return f.cl.Fn.SourceMap.Lookup(f.ip)
+}
@@ -0,0 +1,519 @@
+package vm
+
+import (
+	"fmt"
+	"APE/code"
+	"APE/compiler"
+	"APE/evaluator"
+	"APE/object"
+	"APE/typecheck"
+)
+
+const StackSize = 1 << 16
+const GlobalsSize = 65536
+const MaxFrames = evaluator.MaxCallDepth + 1 // the main frame and as many calls as the evaluator allows
+
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp int // always points to the next free slot, the top of the stack is stack[sp-1]
+
+	globals []object.Object
+	globalNames []string
+
+	frames []*Frame
+	framesIndex int
+
+	handlers []handler // installed try blocks, innermost last
+}
+
+// handler is a try block, an error raised inside it resumes at ip with the stack cut back to sp
+type handler struct {
+	frameIndex int
+	sp int
+	ip int
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, make([]object.Object, GlobalsSize))
+}
+
+// NewWithGlobals lets a REPL keep its globals between inputs
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, SourceMap: bytecode.SourceMap, NumLocals: bytecode.NumLocals}
+	mainFrame := NewFrame(&object.Closure{Fn: mainFn}, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants: bytecode.Constants,
+		stack: make([]object.Object, StackSize),
+		globals: globals,
+		globalNames: bytecode.GlobalNames,
+		frames: frames,
+		framesIndex: 1,
+	}
+}
+
+// Run executes the program and returns the value of its last statement, or the error that stopped it
+func (vm *VM) Run() (result object.Object) {
+	defer func() { // a bug in the VM must not take the host down with it
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+
+	return vm.run(0)
+}
+
+// run executes until the frame at index base returns
+func (vm *VM) run(base int) object.Object {
+	for {
+		frame := vm.currentFrame()
+		frame.ip++
+
+		ip := frame.ip
+		ins := frame.Instructions()
+		op := code.Opcode(ins[ip])
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.push(vm.constants[constIndex])
+
+		case code.OpPop:
+			vm.sp--
+
+		case code.OpNull:
+			err = vm.push(evaluator.NULL)
+
+		case code.OpTrue:
+			err = vm.push(evaluator.TRUE)
+
+		case code.OpFalse:
+			err = vm.push(evaluator.FALSE)
+
+		case code.OpBinary:
+			operator := code.Operators[code.ReadUint8(ins[ip+1:])]
+			frame.ip += 1
+
+			right := vm.stack[vm.sp-1]
+			left := vm.stack[vm.sp-2]
+			vm.sp -= 2
+			err = vm.pushResult(evaluator.EvalInfix(operator, left, right))
+
+		case code.OpPrefix:
+			operator := code.Operators[code.ReadUint8(ins[ip+1:])]
+			frame.ip += 1
+
+			right := vm.pop()
+			err = vm.pushResult(evaluator.EvalPrefix(operator, right))
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			if !evaluator.IsTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			val := vm.globals[globalIndex]
+			if val == nil { // referenced before anything was assigned to it
+				err = newError("identifier not found: %s", vm.globalNames[globalIndex])
+			} else {
+				err = vm.push(val)
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpAssignGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			if vm.globals[globalIndex] == nil {
+				err = newError("assignment to undeclared variable: %s", vm.globalNames[globalIndex])
+			} else {
+				vm.globals[globalIndex] = vm.pop()
+			}
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.pushVariable(load(frame.locals, int(localIndex)), frame, ip)
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			store(frame.locals, int(localIndex), vm.pop())
+
+		case code.OpBox:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			box := &cell{}
+			if _, ok := frame.locals[localIndex].(*cell); !ok {
+				box.value = frame.locals[localIndex]
+			}
+			frame.locals[localIndex] = box
+
+		case code.OpJumpIfSet:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			pos := int(code.ReadUint16(ins[ip+3:]))
+			frame.ip += 4
+
+			if frame.locals[localIndex] != nil {
+				frame.ip = pos - 1
+			}
+
+		case code.OpGetFree:
+			depth := code.ReadUint8(ins[ip+1:])
+			index := code.ReadUint16(ins[ip+2:])
+			frame.ip += 3
+			err = vm.pushVariable(load(frame.cl.Outers[depth-1], int(index)), frame, ip)
+
+		case code.OpSetFree:
+			depth := code.ReadUint8(ins[ip+1:])
+			index := code.ReadUint16(ins[ip+2:])
+			frame.ip += 3
+			store(frame.cl.Outers[depth-1], int(index), vm.pop())
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+
+			err = vm.push(evaluator.BuiltinAt(int(builtinIndex)))
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp -= numElements
+			err = vm.push(&object.Array{Elements: elements})
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			var hash object.Object
+			hash, err = vm.buildHash(vm.sp-numElements, vm.sp)
+			vm.sp -= numElements
+			if err == nil {
+				err = vm.push(hash)
+			}
+
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			str := evaluator.Interpolate(vm.stack[vm.sp-numParts : vm.sp])
+			vm.sp -= numParts
+			err = vm.push(str)
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalIndex(left, index))
+
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalSlice(left, low, high))
+
+		case code.OpSetIndex:
+			val := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.SetIndex(left, index, val))
+
+		case code.OpCall:
+			numArgs := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			err = vm.callFunction(numArgs)
+
+		case code.OpMethodCall:
+			method := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String).Value
+			numArgs := int(code.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+
+			receiver := vm.stack[vm.sp-1-numArgs]
+			args := make([]object.Object, numArgs)
+			copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+			vm.sp -= numArgs + 1
+
+			err = vm.pushResult(evaluator.CallMethod(receiver, method, args, vm.apply))
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			returned := vm.popFrame()
+			vm.dropHandlers(vm.framesIndex)
+			vm.sp = returned.basePointer
+
+			if vm.framesIndex == base {
+				return returnValue
+			}
+			err = vm.push(returnValue)
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			fn := vm.constants[constIndex].(*object.CompiledFunction)
+			// the new function sees this frame's locals first, then everything this function could see. It keeps
+			// the cells the locals are in now, a block entered again boxes its variables afresh for later closures.
+			outers := make([][]object.Object, 0, len(frame.cl.Outers)+1)
+			outers = append(outers, append([]object.Object(nil), frame.locals...))
+			outers = append(outers, frame.cl.Outers...)
+			err = vm.push(&object.Closure{Fn: fn, Outers: outers})
+
+		case code.OpThrow:
+			val := vm.pop()
+			if thrown, ok := val.(*object.Error); ok { // rethrown after a finally block
+				err = thrown
+			} else {
+				err = evaluator.NewThrownError(val)
+			}
+
+		case code.OpTry:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.handlers = append(vm.handlers, handler{frameIndex: vm.framesIndex - 1, sp: vm.sp, ip: pos})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpCatch:
+			thrown := vm.pop().(*object.Error)
+			err = vm.push(evaluator.ErrorToHash(thrown))
+
+		case code.OpCheckType:
+			typ := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.Type)
+			name := vm.constants[code.ReadUint16(ins[ip+3:])].(*object.String)
+			frame.ip += 4
+
+			val := vm.stack[vm.sp-1]
+			if !typecheck.Matches(val, typ.Annotation) {
+				err = newError("type mismatch: cannot assign %s to %s (%s)", val.Type(), name.Value, typ.Annotation)
+			}
+
+		case code.OpCheckElement:
+			typ := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.Type)
+			name := vm.constants[code.ReadUint16(ins[ip+3:])].(*object.String)
+			frame.ip += 4
+
+			if e := evaluator.CheckElementTypes(typ.Annotation, name.Value, vm.stack[vm.sp-2], vm.stack[vm.sp-1]); e != nil {
+				err = e
+			}
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			name := fmt.Sprintf("%d", op)
+			if def != nil {
+				name = def.Name
+			}
+			err = newError("unhandled instruction: %s", name)
+		}
+
+		if err != nil && !vm.raise(err, base) {
+			return err
+		}
+	}
+}
+
+// raise passes err to the innermost try block installed since the frame at index base, unwinding any frames
+// in between. When there is none every frame down to base is unwound and raise returns false.
+func (vm *VM) raise(err *object.Error, base int) bool {
+	if !err.Pos.IsValid() {
+		err.Pos = vm.currentFrame().Pos()
+	}
+
+	if n := len(vm.handlers); n > 0 && vm.handlers[n-1].frameIndex >= base {
+		h := vm.handlers[n-1]
+		vm.handlers = vm.handlers[:n-1]
+
+		for vm.framesIndex-1 > h.frameIndex {
+			vm.unwindFrame(err)
+		}
+
+		vm.sp = h.sp
+		vm.stack[vm.sp] = err
+		vm.sp++
+		vm.currentFrame().ip = h.ip - 1
+		return true
+	}
+
+	for vm.framesIndex > base {
+		vm.unwindFrame(err)
+	}
+	return false
+}
+
+// unwindFrame pops the current frame because err passed through it, recording the call in the error's stack
+func (vm *VM) unwindFrame(err *object.Error) {
+	frame := vm.popFrame()
+	if vm.framesIndex > 0 {
+		err.Stack = append(err.Stack, object.StackFrame{Function: frame.cl.Fn.Name, Pos: vm.currentFrame().Pos()})
+	}
+	vm.sp = frame.basePointer
+}
+
+func (vm *VM) dropHandlers(frameIndex int) {
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frameIndex >= frameIndex {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+}
+
+func (vm *VM) callFunction(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp -= numArgs + 1
+		return vm.pushResult(callee.Fn(args...))
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) *object.Error {
+	fn := cl.Fn
+	if err := evaluator.CheckArity(fn.Name, fn.NumParameters-fn.NumDefaults, fn.NumDefaults, fn.Rest, numArgs); err != nil {
+		return err
+	}
+
+	if vm.framesIndex >= MaxFrames {
+		return newError("stack overflow")
+	}
+
+	basePointer := vm.sp - 1 - numArgs
+	frame := NewFrame(cl, basePointer)
+	passed := min(numArgs, fn.NumParameters)
+	copy(frame.locals, vm.stack[vm.sp-numArgs:vm.sp-numArgs+passed])
+	if fn.Rest { // the arguments after the named parameters
+		rest := make([]object.Object, numArgs-passed)
+		copy(rest, vm.stack[vm.sp-numArgs+passed:vm.sp])
+		frame.locals[fn.NumParameters] = &object.Array{Elements: rest}
+	}
+	vm.sp = basePointer + 1
+	vm.pushFrame(frame)
+
+	return nil
+}
+
+// apply calls fn from inside a builtin method, running the VM until the call returns
+func (vm *VM) apply(fn object.Object, args []object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *object.Closure:
+		if err := vm.push(fn); err != nil {
+			return err
+		}
+		for _, a := range args {
+			if err := vm.push(a); err != nil {
+				return err
+			}
+		}
+		if err := vm.callClosure(fn, len(args)); err != nil {
+			vm.sp -= len(args) + 1
+			return err
+		}
+		return vm.run(vm.framesIndex - 1)
+	case *object.Builtin:
+		return fn.Fn(args...)
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, *object.Error) {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+func (vm *VM) push(o object.Object) *object.Error {
+	if vm.sp >= StackSize {
+		return newError("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+// pushVariable pushes the value of a local or free variable, which is unset if the function defining it has not got to its let yet
+func (vm *VM) pushVariable(val object.Object, frame *Frame, ip int) *object.Error {
+	if val == nil {
+		return newError("identifier not found: %s", frame.cl.Fn.Names[ip])
+	}
+	return vm.push(val)
+}
+
+// pushResult pushes the result of an operation, or returns it if the operation failed
+func (vm *VM) pushResult(o object.Object) *object.Error {
+	if err, ok := o.(*object.Error); ok {
+		return err
+	}
+	if o == nil {
+		o = evaluator.NULL
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
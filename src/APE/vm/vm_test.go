@@ -0,0 +1,313 @@
+package vm
+
+import (
+	"APE/ast"
+	"APE/compiler"
+	"APE/evaluator"
+	"APE/lexer"
+	"APE/object"
+	"APE/parser"
+	"APE/resolver"
+	"testing"
+)
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []struct {
+		input string
+		expected int64
+	}{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"-5 + 10", 5},
+		{"7 % 3", 1},
+		{"6 & 3 | 8", 10},
+		{"1 << 4 >> 2", 4},
+		{"~0", -1},
+		{"let a = 5; a = a * 2; a;", 10},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, tt.input, runVM(t, tt.input), tt.expected)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input string
+		expected int64
+	}{
+		{"let newAdder = fn(a) { fn(b) { a + b } }; newAdder(2)(3);", 5},
+		{"let newAdder = fn(a, b) { let c = a + b; fn(d) { fn(e) { c + d + e } } }; newAdder(1, 2)(3)(4);", 10},
+		{"let counter = fn() { let c = 0; fn() { c = c + 1; c } }; let f = counter(); f(); f(); f();", 3},
+		{"let f = fn() { let x = 1; let g = fn() { x = x + 10 }; g(); x }; f();", 11},
+		{"let f = fn() { let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } }; countDown(3) }; f();", 0},
+		{"let wrapper = fn() { let inner = fn() { later() }; let later = fn() { 7 }; inner() }; wrapper();", 7},
+		{"let g = fn() { late }; let late = 4; g();", 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, tt.input, runVM(t, tt.input), tt.expected)
+	}
+}
+
+// TestVMMatchesEvaluator runs each program on both engines, they have to agree on every value and error
+func TestVMMatchesEvaluator(t *testing.T) {
+	inputs := []string{
+		"5; 10",
+		`1 / 0; "after"`,
+		`let a: array<int> = [1]; let f = fn() { "x" }; a[0] = f(); a`,
+		`let h: hash<string, int> = {"a": 1}; let f = fn() { true }; h["b"] = f(); h`,
+		`let m: array<array<int>> = [[1]]; let f = fn() { "x" }; m[0][0] = f(); m`,
+		`let a: array<int> = [1]; let f = fn() { 2 }; a[0] = f(); a`,
+		"let x = 1; if (true) { let x = 2 }; x",
+		"let x = 1; if (true) { x = 5 }; x",
+		"let x = 1; let i = 0; while (i < 3) { let x = i; i = i + 1 }; x",
+		"let f = fn() { let x = 1; if (true) { let x = 2 }; x }; f()",
+		"let fs = []; let i = 0; while (i < 3) { let j = i; fs = push(fs, fn() { j }); i = i + 1; }; fs.map(fn(f) { f() })",
+		"let make = fn() { let fs = []; let i = 0; while (i < 3) { let j = i; fs = push(fs, fn() { j }); i = i + 1; }; fs }; make().map(fn(f) { f() })",
+		"let fs = []; for (let i = 0; i < 3; i = i + 1) { let j = i * 10; fs = push(fs, fn() { j }) }; fs.map(fn(f) { f() })",
+		"let fs = []; for (let i = 0; i < 3; i = i + 1) { fs = push(fs, fn() { i }) }; fs.map(fn(f) { f() })",
+		"let fs = []; let i = 0; while (i < 2) { let j = i; let inc = fn() { j = j + 10 }; inc(); fs = push(fs, fn() { j }); i = i + 1; }; fs.map(fn(f) { f() })",
+		"let counter = fn() { let n = 0; fn() { n = n + 1; n } }; let c = counter(); c(); c(); c()",
+		"let f = fn() { let fs = []; let n = 0; let i = 0; while (i < 3) { let j = i; fs = push(fs, fn() { n = n + j }); i = i + 1; }; fs.map(fn(g) { g() }); n }; f()",
+		"if (true) { let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5) }",
+		"try { throw 1 } catch (e) { let g = fn() { e[\"value\"] }; g() }",
+		"if (true) { let inner = 1 }; inner",
+		"let x = 5; x",
+		"true == false",
+		"1 < 2 && 2 < 1",
+		"0 || 3",
+		"!5",
+		"1.5 + 2",
+		"10 % 3.5",
+		"int(3.9) + float(2)",
+		`"Hello" + " " + "World!"`,
+		`len("four")`,
+		`let größe = "héllo"; [len(größe), größe[1], größe[1:3], größe[:2], größe[3:], größe[9]]`,
+		`[1, 2, 3, 4][1:3] + [1, 2, 3][:1]`,
+		`let a = [1, 2, 3]; let n = 1; [a[n:], a[:n + 1], bytes("é")]`,
+		`"abc"[true:]`,
+		`let user = {"name": "ada"}; let n = 3; "hello ${user["name"]}, you have ${n * 2} items"`,
+		`let f = fn(x) { "<${x}> ${"${x + 1}"}" }; f(1) + "${[1.5, 'c']}"`,
+		`"before ${1 / 0} after"`,
+		`"${""}"`,
+		"let q = `\n    SELECT ${x}\n      FROM t\n    `; q + `\\n`",
+		"if (1 > 2) { 10 }",
+		"if (1 < 2) { 10 } else { 20 }",
+		"[1, 2 * 2, 3 + 3][1]",
+		"[1, 2, 3][3]",
+		`{"one": 1}["one"]`,
+		`{true: 5}[true]`,
+		"let x = 0; while (x < 5) { x = x + 1; }; x",
+		"let x = 0; for (let i = 0; i < 10; i = i + 1) { if (i == 3) { break; }; x = x + 1; }; x",
+		"let f = fn() { for (let i = 0; i < 10; i = i + 1) { if (i == 3) { return i; }; }; 99 }; f()",
+		"let x = 0; for (let i = 0; i < 5; i = i + 1) { if (i == 2) { continue; }; x = x + i; }; x",
+		"let f = fn() { for (let i = 0; i < 5; i = i + 1) { if (i == 2) { continue; }; if (i == 4) { return i; }; }; 99 }; f()",
+		"let x = 0; for (;;) { x = x + 1; if (x == 6) { break; }; }; x",
+		"let f = fn() { for (;;) { break; }; 6 }; f()",
+		"let f = fn(x) { while (true) { return x * 2; } }; f(4)",
+		"let f = fn(x) { x }; f(1) + f(2)",
+		"[1, 2, 3].map(fn(x) { x * 2 })",
+		"[1, 2, 3, 4].filter(fn(x) { x % 2 == 0 })",
+		`["a", "bb"].map(len) + [1, 2].reduce(push, [])`,
+		`let s = " Hello, World "; [s.trim().upper(), s.split(","), s.contains("lo"), s.replace("o", "0")]`,
+		`let h = {"b": 2, "a": 1}; let m = h.merge({"c": 3}); [h.delete("b"), h.has("b"), m.keys(), m.values()]`,
+		"[(-5).abs(), 2.pow(100), (-9223372036854775807 - 1).abs()]",
+		"[1].map(fn(x) { x.pow(-1) })",
+		"true.not()",
+		`let h = {1: "a", 2.5: "b"}; [h[1.0], h[2.5], h[2]]`,
+		`"a".split()`,
+		"[1, 2, 3].reduce(fn(acc, x) { acc + x }, 0)",
+		`try { throw "boom" } catch (e) { e["message"] }`,
+		`try { 1 + true } catch (e) { e["message"] }`,
+		`try { throw {"code": 4} } catch (e) { e["value"]["code"] }`,
+		`let x = 0; let r = try { 1 } finally { x = 5 }; r + x`,
+		`let x = 0; try { try { throw 1 } finally { x = 2 } } catch (e) { x + e["value"] }`,
+		`let x = 0; try { try { throw 1 } catch (e) { throw 3 } finally { x = 2 } } catch (e) { x + e["value"] }`,
+		`let f = fn() { try { return 1 } finally { puts("") } }; f()`,
+		`let x = 0; for (let i = 0; i < 5; i = i + 1) { try { if (i == 3) { break; }; x = x + 1 } catch (e) { 0 } }; x`,
+		`let f = fn() { for (let i = 0; i < 5; i = i + 1) { try { if (i == 3) { return i; } } catch (e) { 0 } }; 99 }; f()`,
+		`let f = fn() { throw "inner" }; try { [1].map(fn(x) { f() }) } catch (e) { len(e["stack"]) }`,
+		`let f = fn(g) { g() }; try { f(fn() { throw 2 }) } catch (e) { e["value"] }`,
+		"let a = 1; a = a + 1; a",
+		"foobar",
+		"5 + true;",
+		"-true",
+		`"a" - "b"`,
+		"1 >> -1",
+		"let f = fn() { 1 + true }; let g = fn() { f() }; g()",
+		"[1, 2].map(fn(x) { x + true })",
+		"throw 5",
+		`{[1]: 2}`,
+		"1()",
+		"let counter = fn() { let c = 0; fn() { c = c + 1 } }; let a = counter(); let b = counter(); a(); a(); b() + a()",
+		"let x = 1; let f = fn() { let x = 5; x = x + 1; x }; f() + x",
+		"let f = fn() { late = 2 }; let late = 1; f(); late",
+		"y = 5",
+		"let f = fn() { nope = 1 }; f()",
+		`let h = {"a": [1, 2]}; h["a"][0] = 10; h["b"] = 5; h["a"][0] + h["b"]`,
+		"let a = [1, 2, 3]; let b = a; a[2] = 30; b[2]",
+		"let f = fn() { let a = [0]; let g = fn() { a[0] = a[0] + 1 }; g(); g(); a[0] }; f()",
+		"let a = [1, 2, 3]; a[3] = 4",
+		"let h = {}; h[[1]] = 1",
+		"'a' + \"pe\"",
+		"'a' < 'b'",
+		"'a' - 'b'",
+		"chr(ord('a') + 1)",
+		"{'a': 1}['a']",
+		"let c: char = 'c'; c = \"c\"",
+		"let x: int = 7; x = 8; x",
+		"let x: int = 1; let set = fn() { x = 5 }; set(); x",
+		"let x: int = 1; let set = fn() { x = 5 }; set()",
+		"let x: int = 1; let x = \"a\"; x = true; x",
+		`let x: int = 1; let set = fn(v) { x = v }; set(1.5)`,
+		`let g = fn() { [1, "a"] }; let nums: array<int> = g();`,
+		`let f = fn() { let n: int = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n = "x" }; f()`,
+		"let f = fn() { let g = fn() { h() }; let r = g(); let h = fn() { 1 }; r }; f()",
+		"let f = fn() { let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10) }; f()",
+		"let f = fn(a) { a }; f()",
+		"let add = fn(a, b) { a + b }; let g = fn() { add(1) }; g()",
+		"fn(a) { a }(1, 2)",
+		"let f = fn(a, b = 2) { a + b }; [f(1), f(1, 5)]",
+		"let f = fn(a, b = a * 10, c = a + b) { [a, b, c] }; [f(1), f(1, 2), f(1, 2, 3)]",
+		"let f = fn(a, b = 2) { a }; f()",
+		"let f = fn(a, b = 2) { a }; f(1, 2, 3)",
+		"let f = fn(a, b = nope) { a }; f(1)",
+		"let f = fn(first, ...rest) { [first, rest] }; [f(1), f(1, 2, 3)]",
+		"let f = fn(...all) { all }; f()",
+		"let f = fn(a, b = 0, ...rest) { [a, b, rest] }; [f(1), f(1, 2), f(1, 2, 3, 4)]",
+		"let f = fn(first, ...rest) { first }; f()",
+		"let sum = fn(...nums) { let total = 0; for (let i = 0; i < 3; i = i + 1) { total = total + nums[i] }; total }; sum(1, 2, 3)",
+		"let make = fn(n) { fn(x = n) { x } }; let g = make(7); [g(), g(1)]",
+		"[1, 2].map(fn(x, y = 10) { x + y })",
+		"1 / 0",
+		"let f = fn(n) { 10 % n }; f(0)",
+		"9223372036854775807 + 1",
+		"-9223372036854775807 - 2",
+		"4611686018427387904 * 2",
+		"-(-9223372036854775807 - 1)",
+		"(-9223372036854775807 - 1) / -1",
+		"(-9223372036854775807 - 1) % -1",
+		"1 << 63",
+		"123456789012345678901234567890 * 10 + 5",
+		"(9223372036854775807 + 1) / 2",
+		"-9223372036854775808",
+		"~99999999999999999999 - 1",
+		`{99999999999999999999: "big"}[99999999999999999998 + 1]`,
+		"let total: int = 9223372036854775807; total = total * 3; total",
+		"99999999999999999999 / 0",
+		"0xff + 0o17 + 0b101 + 1_000_000",
+		"0xFFFF_FFFF_FFFF_FFFF_FF & 0xF0",
+		"let f = fn(n) { f(n + 1) }; f(0)",
+	}
+
+	for _, input := range inputs {
+		program := parse(t, input)
+		resolver.New(evaluator.BuiltinNames()).Resolve(program) // undefined names still have to fail at runtime on both
+
+		expected := evaluator.Eval(program, object.NewEnvironment())
+		actual := runProgram(t, input, program)
+
+		if expected.Inspect() != actual.Inspect() {
+			t.Errorf("engines disagree on %q. eval=%s, vm=%s", input, expected.Inspect(), actual.Inspect())
+			continue
+		}
+
+		expectedErr, ok := expected.(*object.Error)
+		if !ok {
+			continue
+		}
+		actualErr := actual.(*object.Error)
+		if expectedErr.Traceback() != actualErr.Traceback() {
+			t.Errorf("engines disagree on the traceback of %q.\neval=%s\nvm=%s", input, expectedErr.Traceback(), actualErr.Traceback())
+		}
+	}
+}
+
+func TestGlobalsPersistBetweenRuns(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	globals := make([]object.Object, GlobalsSize)
+
+	var result object.Object
+	for _, input := range []string{"let x = 2;", "let double = fn(n) { n * 2 };", "double(x) + 1"} {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(t, input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+		result = NewWithGlobals(bytecode, globals).Run()
+	}
+
+	testIntegerObject(t, "double(x) + 1", result, 5)
+}
+
+const fibonacciProgram = `
+let fibonacci = fn(x) {
+	if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+fibonacci(20);
+`
+
+func BenchmarkFibonacciEval(b *testing.B) {
+	program := parser.New(lexer.New(fibonacciProgram)).ParseProgram()
+	resolver.New(evaluator.BuiltinNames()).Resolve(program)
+
+	for i := 0; i < b.N; i++ {
+		evaluator.Eval(program, object.NewEnvironment())
+	}
+}
+
+func BenchmarkFibonacciVM(b *testing.B) {
+	program := parser.New(lexer.New(fibonacciProgram)).ParseProgram()
+
+	for i := 0; i < b.N; i++ {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			b.Fatal(err)
+		}
+		New(comp.Bytecode()).Run()
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+func runVM(t *testing.T, input string) object.Object {
+	t.Helper()
+	return runProgram(t, input, parse(t, input))
+}
+
+func runProgram(t *testing.T, input string, program *ast.Program) object.Object {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+
+	return New(comp.Bytecode()).Run()
+}
+
+func testIntegerObject(t *testing.T, input string, obj object.Object, expected int64) {
+	t.Helper()
+
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("%q: object is not Integer. got=%T (%+v)", input, obj, obj)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%q: object has wrong value. got=%d, want=%d", input, result.Value, expected)
+	}
+}